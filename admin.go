@@ -0,0 +1,262 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServeAdmin serves a JSON API for debugging resources running in this
+// process, on network "tcp" or "unix". It is meant to be reached locally
+// only, and there is no authentication.
+//
+//	GET /status       registration status and kubelet restarts
+//	GET /devices      devices with attributes, health as advertised and
+//	                  reported by source, and health history
+//	GET /allocations  recent allocations
+//	GET /owners       containers devices are assigned to, if tracked
+//
+// Add "?resource=<name>" to view only one resource.
+//
+//	POST /cordon?resource=<name>&device=<id>    stop new allocations of device
+//	POST /uncordon?resource=<name>&device=<id>  allow them again
+//
+// Resources in dry run also take, with "&device=<id>" for each device of a
+// container:
+//
+//	POST /allocate?resource=<name>&device=<id>  call Allocate
+//	POST /prestart?resource=<name>&device=<id>  call PreStartContainer
+func ServeAdmin(network, address string) error {
+	if network == "unix" {
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	log.Println("Serving admin API on", address)
+	return http.Serve(l, AdminHandler())
+}
+
+// AdminHandler returns the handler of admin API, for serving it along with
+// other handlers.
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", adminView(statusView))
+	mux.HandleFunc("/devices", adminView(devicesView))
+	mux.HandleFunc("/allocations", adminView(allocationsView))
+	mux.HandleFunc("/owners", adminView(ownersView))
+	mux.HandleFunc("/cordon", adminCordon(true))
+	mux.HandleFunc("/uncordon", adminCordon(false))
+	mux.HandleFunc("/allocate", adminDryRun("allocate"))
+	mux.HandleFunc("/prestart", adminDryRun("prestart"))
+	return mux
+}
+
+type statusResponse struct {
+	Registered   bool      `json:"registered"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	Error        string    `json:"error,omitempty"`
+	Restarts     int       `json:"kubeletRestarts"`
+	Synced       bool      `json:"synced"`
+	Devices      int       `json:"devices"`
+	Cordoned     []string  `json:"cordoned,omitempty"`
+	DryRun       bool      `json:"dryRun,omitempty"`
+}
+
+type deviceResponse struct {
+	ID string `json:"id"`
+	// Health is as advertised to kubelet, while SourceHealth is as source
+	// reports, which differ if device is cordoned, being released or left
+	// out of CDI spec.
+	Health        string            `json:"health"`
+	SourceHealth  string            `json:"sourceHealth"`
+	NUMANodes     []int64           `json:"numaNodes,omitempty"`
+	Attrs         map[string]string `json:"attrs,omitempty"`
+	History       []healthEvent     `json:"history"`
+	LastAllocated *time.Time        `json:"lastAllocated,omitempty"`
+	CordonedAt    *time.Time        `json:"cordonedAt,omitempty"`
+	Owner         *Owner            `json:"owner,omitempty"`
+}
+
+func statusView(s *resourceState) interface{} {
+	devs, synced := s.cache.List()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	resp := statusResponse{
+		Registered:   s.registered,
+		RegisteredAt: s.registeredAt,
+		Restarts:     s.restarts,
+		Synced:       synced,
+		Devices:      len(devs),
+		Cordoned:     s.cordons.List(),
+		DryRun:       s.dryRun != nil,
+	}
+	if s.registerErr != nil {
+		resp.Error = s.registerErr.Error()
+	}
+	return resp
+}
+
+func devicesView(s *resourceState) interface{} {
+	devs, _ := s.cache.List()
+	advertised, _ := s.advertised()
+	health := make(map[string]string, len(advertised))
+	for _, d := range advertised {
+		health[d.ID] = d.Health
+	}
+	resp := make([]deviceResponse, 0, len(devs))
+	for _, d := range devs {
+		dr := deviceResponse{
+			ID:           d.ID,
+			Health:       health[d.ID],
+			SourceHealth: d.Health,
+			Attrs:        d.Attrs,
+			History:      s.cache.History(d.ID),
+		}
+		if dr.Health == "" {
+			// Device is added since advertised devices are listed.
+			dr.Health = d.Health
+		}
+		if d.Topology != nil {
+			for _, n := range d.Topology.Nodes {
+				dr.NUMANodes = append(dr.NUMANodes, n.ID)
+			}
+		}
+		if t, ok := s.ledger.LastAllocated(d.ID); ok {
+			dr.LastAllocated = &t
+		}
+		if t, ok := s.cordons.Get(d.ID); ok {
+			dr.CordonedAt = &t
+		}
+		if s.owners != nil {
+			if o, ok := s.owners.Get(d.ID); ok {
+				dr.Owner = &o
+			}
+		}
+		resp = append(resp, dr)
+	}
+	return resp
+}
+
+func allocationsView(s *resourceState) interface{} {
+	return s.ledger.Records()
+}
+
+func ownersView(s *resourceState) interface{} {
+	if s.owners == nil {
+		return map[string]Owner{}
+	}
+	return s.owners.List()
+}
+
+// adminView responds view of each resource, keyed by resource name.
+func adminView(view func(*resourceState) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resource := r.URL.Query().Get("resource")
+		resp := map[string]interface{}{}
+		for _, s := range runningStates() {
+			if resource == "" || resource == s.resourceName {
+				resp[s.resourceName] = view(s)
+			}
+		}
+		if resource != "" && len(resp) == 0 {
+			http.Error(w, "resource not found", http.StatusNotFound)
+			return
+		}
+		writeAdminResponse(w, resp)
+	}
+}
+
+// postedState returns the resource and devices a POST request is for, or
+// responds an error and returns false.
+func postedState(w http.ResponseWriter, r *http.Request) (*resourceState, []string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+
+	resource, ids := r.URL.Query().Get("resource"), r.URL.Query()["device"]
+	if resource == "" || len(ids) == 0 {
+		http.Error(w, "resource and device are required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	for _, s := range runningStates() {
+		if s.resourceName == resource {
+			return s, ids, true
+		}
+	}
+	http.Error(w, "resource not found", http.StatusNotFound)
+	return nil, nil, false
+}
+
+func writeAdminResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Println("Could not write admin response:", err)
+	}
+}
+
+// adminCordon cordons or uncordons a device, and responds cordoned devices
+// of the resource.
+func adminCordon(cordoned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, ids, ok := postedState(w, r)
+		if !ok {
+			return
+		}
+
+		if err := state.cordon(ids[0], cordoned); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeAdminResponse(w, map[string][]string{"cordoned": state.cordons.List()})
+	}
+}
+
+// adminDryRun calls Allocate or PreStartContainer of a resource in dry
+// run, and responds the result.
+func adminDryRun(cmd string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, ids, ok := postedState(w, r)
+		if !ok {
+			return
+		}
+		// Calls to a registered plugin would be unknown to kubelet.
+		if state.dryRun == nil {
+			http.Error(w, "resource is not in dry run", http.StatusConflict)
+			return
+		}
+
+		result, err := state.dryRun.dryRunCommand(cmd, ids)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeAdminResponse(w, result)
+	}
+}
+
+func runningStates() []*resourceState {
+	states.RLock()
+	defer states.RUnlock()
+	running := make([]*resourceState, 0, len(states.m))
+	for _, s := range states.m {
+		running = append(running, s)
+	}
+	return running
+}
@@ -0,0 +1,82 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func adminRequest(method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func TestAdminDevices(t *testing.T) {
+	s := newResourceState(Config{ResourceName: "example.com/admin"})
+	s.cache.Set([]*Device{
+		{ID: "dev-0", Health: pluginapi.Healthy},
+		{ID: "dev-1", Health: pluginapi.Healthy},
+		{ID: "dev-2", Health: pluginapi.Unhealthy},
+	})
+	registerState(s)
+	defer unregisterState(s)
+
+	if w := adminRequest(http.MethodPost, "/cordon?resource=example.com/admin&device=dev-1"); w.Code != http.StatusOK {
+		t.Fatalf("cordon responded %d: %s", w.Code, w.Body)
+	}
+	w := adminRequest(http.MethodGet, "/devices?resource=example.com/admin")
+	var resp map[string][]deviceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body, err)
+	}
+	want := map[string][2]string{
+		"dev-0": {pluginapi.Healthy, pluginapi.Healthy},
+		"dev-1": {pluginapi.Unhealthy, pluginapi.Healthy},
+		"dev-2": {pluginapi.Unhealthy, pluginapi.Unhealthy},
+	}
+	for _, d := range resp["example.com/admin"] {
+		if got := [2]string{d.Health, d.SourceHealth}; got != want[d.ID] {
+			t.Errorf("%s health and source health are %v, want %v", d.ID, got, want[d.ID])
+		}
+		if (d.CordonedAt != nil) != (d.ID == "dev-1") {
+			t.Errorf("%s cordoned at %v", d.ID, d.CordonedAt)
+		}
+	}
+	if len(resp["example.com/admin"]) != len(want) {
+		t.Errorf("got %d devices, want %d", len(resp["example.com/admin"]), len(want))
+	}
+}
+
+func TestAdminPost(t *testing.T) {
+	s := newResourceState(Config{ResourceName: "example.com/admin"})
+	s.cache.Set([]*Device{{ID: "dev-0", Health: pluginapi.Healthy}})
+	registerState(s)
+	defer unregisterState(s)
+
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{http.MethodGet, "/cordon?resource=example.com/admin&device=dev-0", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/cordon?resource=example.com/admin", http.StatusBadRequest},
+		{http.MethodPost, "/cordon?resource=example.com/none&device=dev-0", http.StatusNotFound},
+		{http.MethodPost, "/cordon?resource=example.com/admin&device=dev-9", http.StatusBadRequest},
+		{http.MethodPost, "/cordon?resource=example.com/admin&device=dev-0", http.StatusOK},
+		{http.MethodPost, "/uncordon?resource=example.com/admin&device=dev-0", http.StatusOK},
+		{http.MethodPost, "/uncordon?resource=example.com/admin&device=dev-9", http.StatusOK},
+		{http.MethodPost, "/allocate?resource=example.com/none&device=dev-0", http.StatusNotFound},
+		{http.MethodPost, "/allocate?resource=example.com/admin&device=dev-0", http.StatusConflict},
+		{http.MethodGet, "/prestart?resource=example.com/admin&device=dev-0", http.StatusMethodNotAllowed},
+	} {
+		if w := adminRequest(tc.method, tc.url); w.Code != tc.want {
+			t.Errorf("%s %s responded %d, want %d: %s", tc.method, tc.url, w.Code, tc.want, w.Body)
+		}
+	}
+	if ids := s.cordons.List(); len(ids) != 0 {
+		t.Errorf("got cordoned %v after uncordon", ids)
+	}
+}
@@ -0,0 +1,117 @@
+package deviceplugin
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Allocation is what Allocate responded for devices of a container.
+type Allocation struct {
+	DevicesIDs []string
+	// Response shall not be changed.
+	Response *pluginapi.ContainerAllocateResponse
+	Time     time.Time
+}
+
+// AllocationPreStartFunc is like PreStartFunc, but also receives the
+// allocation of the devices, which is nil if it's not found, such as when
+// plugin restarted since Allocate.
+type AllocationPreStartFunc func(ids []string, allocation *Allocation) error
+
+const defaultAllocationTTL = 5 * time.Minute
+
+// allocationMemo remembers allocations keyed by their set of devices, for
+// PreStartContainer which receives only device IDs. Since kubelet calls
+// PreStartContainer again when a container restarts, an allocation is kept
+// until any of its devices is allocated again, or ttl after allocated if it
+// never gets a PreStartContainer. So there are no more allocations than
+// devices.
+type allocationMemo struct {
+	ttl time.Duration
+
+	lock sync.Mutex
+	// allocations are keyed by sorted device IDs.
+	allocations map[string]*Allocation
+	// started are keys of allocations which got a PreStartContainer.
+	started map[string]bool
+	// keys maps device ID to key of its allocation.
+	keys map[string]string
+}
+
+func newAllocationMemo(ttl time.Duration) *allocationMemo {
+	if ttl <= 0 {
+		ttl = defaultAllocationTTL
+	}
+	return &allocationMemo{
+		ttl:         ttl,
+		allocations: map[string]*Allocation{},
+		started:     map[string]bool{},
+		keys:        map[string]string{},
+	}
+}
+
+func allocationKey(ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	// Device IDs are sent in protobuf strings, never holding NUL in
+	// practice.
+	return strings.Join(sorted, "\x00")
+}
+
+// Put remembers resp allocated to ids, replacing allocations of any of
+// them.
+func (m *allocationMemo) Put(ids []string, resp *pluginapi.ContainerAllocateResponse) {
+	now := time.Now()
+	key := allocationKey(ids)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, id := range ids {
+		if old, ok := m.keys[id]; ok {
+			m.removeLocked(old)
+		}
+	}
+	for k, a := range m.allocations {
+		if !m.started[k] && now.Sub(a.Time) > m.ttl {
+			m.removeLocked(k)
+		}
+	}
+
+	m.allocations[key] = &Allocation{DevicesIDs: append([]string(nil), ids...), Response: resp, Time: now}
+	for _, id := range ids {
+		m.keys[id] = key
+	}
+}
+
+// Started returns allocation of ids, and keeps it for later restarts of the
+// container.
+func (m *allocationMemo) Started(ids []string) *Allocation {
+	key := allocationKey(ids)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	a, ok := m.allocations[key]
+	if !ok || (!m.started[key] && time.Since(a.Time) > m.ttl) {
+		return nil
+	}
+	m.started[key] = true
+	return a
+}
+
+func (m *allocationMemo) removeLocked(key string) {
+	a, ok := m.allocations[key]
+	if !ok {
+		return
+	}
+	for _, id := range a.DevicesIDs {
+		if m.keys[id] == key {
+			delete(m.keys, id)
+		}
+	}
+	delete(m.allocations, key)
+	delete(m.started, key)
+}
@@ -0,0 +1,105 @@
+package deviceplugin
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestAllocationMemo(t *testing.T) {
+	m := newAllocationMemo(0)
+	if m.ttl != defaultAllocationTTL {
+		t.Errorf("got ttl %v, want %v", m.ttl, defaultAllocationTTL)
+	}
+	resp := &pluginapi.ContainerAllocateResponse{Envs: map[string]string{"DEV": "b,a"}}
+	m.Put([]string{"dev-b", "dev-a"}, resp)
+
+	// Kubelet may send IDs of PreStartContainer in another order.
+	a := m.Started([]string{"dev-a", "dev-b"})
+	if a == nil {
+		t.Fatal("allocation is not found by sorted IDs")
+	}
+	if a.Response != resp || !reflect.DeepEqual(a.DevicesIDs, []string{"dev-b", "dev-a"}) {
+		t.Errorf("got allocation %+v", a)
+	}
+	for _, ids := range [][]string{{"dev-a"}, {"dev-a", "dev-b", "dev-c"}, nil} {
+		if a := m.Started(ids); a != nil {
+			t.Errorf("got allocation of %v, want none", ids)
+		}
+	}
+
+	// A container restarting gets the same allocation.
+	if m.Started([]string{"dev-b", "dev-a"}) != a {
+		t.Errorf("allocation is not kept for restart")
+	}
+}
+
+func TestAllocationMemoReplace(t *testing.T) {
+	m := newAllocationMemo(time.Minute)
+	m.Put([]string{"dev-a", "dev-b"}, &pluginapi.ContainerAllocateResponse{})
+	m.Started([]string{"dev-a", "dev-b"})
+	m.Put([]string{"dev-c"}, &pluginapi.ContainerAllocateResponse{})
+	replaced := &pluginapi.ContainerAllocateResponse{}
+	m.Put([]string{"dev-b", "dev-d"}, replaced)
+
+	if a := m.Started([]string{"dev-a", "dev-b"}); a != nil {
+		t.Errorf("started allocation of dev-a, dev-b is kept after dev-b is allocated again")
+	}
+	if a := m.Started([]string{"dev-b", "dev-d"}); a == nil || a.Response != replaced {
+		t.Errorf("got allocation %+v, want the new one", a)
+	}
+	if a := m.Started([]string{"dev-c"}); a == nil {
+		t.Errorf("allocation of dev-c is removed")
+	}
+
+	// No more allocations than devices are kept.
+	if len(m.allocations) != 2 || len(m.started) != 2 {
+		t.Errorf("got %d allocations, %d started, want 2 each", len(m.allocations), len(m.started))
+	}
+	var ids []string
+	for id := range m.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if want := []string{"dev-b", "dev-c", "dev-d"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got keys of %v, want %v", ids, want)
+	}
+}
+
+func TestAllocationMemoTTL(t *testing.T) {
+	m := newAllocationMemo(time.Minute)
+	m.Put([]string{"dev-a"}, &pluginapi.ContainerAllocateResponse{})
+	m.Put([]string{"dev-b"}, &pluginapi.ContainerAllocateResponse{})
+	m.Put([]string{"dev-c"}, &pluginapi.ContainerAllocateResponse{})
+	m.Started([]string{"dev-b"})
+	expired := time.Now().Add(-2 * time.Minute)
+	for _, a := range m.allocations {
+		a.Time = expired
+	}
+
+	// Allocations expire unless started, such as when pods are deleted
+	// before containers start.
+	if a := m.Started([]string{"dev-a"}); a != nil {
+		t.Errorf("got expired allocation of dev-a")
+	}
+	if a := m.Started([]string{"dev-b"}); a == nil {
+		t.Errorf("started allocation of dev-b expired")
+	}
+
+	// Expired allocations are removed by the next Put.
+	m.Put([]string{"dev-d"}, &pluginapi.ContainerAllocateResponse{})
+	for _, key := range []string{"dev-a", "dev-c"} {
+		if _, ok := m.allocations[key]; ok {
+			t.Errorf("expired allocation of %s is kept", key)
+		}
+		if _, ok := m.keys[key]; ok {
+			t.Errorf("key of expired %s is kept", key)
+		}
+	}
+	if len(m.allocations) != 2 {
+		t.Errorf("got %d allocations, want 2", len(m.allocations))
+	}
+}
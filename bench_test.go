@@ -0,0 +1,162 @@
+package deviceplugin
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// benchDevices is the number of devices of the resource benchmarked. Run
+// with a different number like:
+//
+//	go test -run - -bench . -args -devices 50000
+var benchDevices = flag.Int("devices", 10000, "number of devices of resource benchmarked")
+
+func benchmarkDevices(n int) []*Device {
+	devs := make([]*Device, n)
+	for i := range devs {
+		devs[i] = &Device{ID: fmt.Sprintf("dev-%d", i), Health: pluginapi.Healthy}
+	}
+	return devs
+}
+
+// flipped returns devs with health of device i flipped.
+func flipped(devs []*Device, i int) []*Device {
+	updated := append([]*Device(nil), devs...)
+	d := *updated[i]
+	if d.Health == pluginapi.Healthy {
+		d.Health = pluginapi.Unhealthy
+	} else {
+		d.Health = pluginapi.Healthy
+	}
+	updated[i] = &d
+	return updated
+}
+
+// BenchmarkCacheSet flips health of one device on each update, like a
+// flapping sensor does.
+func BenchmarkCacheSet(b *testing.B) {
+	n := *benchDevices
+	c := newDeviceCache()
+	devs := benchmarkDevices(n)
+	c.Set(devs)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		devs = flipped(devs, i%n)
+		c.Set(devs)
+	}
+}
+
+func BenchmarkAdvertised(b *testing.B) {
+	benchmarkAdvertised(b, false)
+}
+
+func BenchmarkAdvertisedCordoned(b *testing.B) {
+	benchmarkAdvertised(b, true)
+}
+
+func benchmarkAdvertised(b *testing.B, cordoned bool) {
+	n := *benchDevices
+	s := newResourceState(Config{ResourceName: "example.com/bench"})
+	s.cache.Set(benchmarkDevices(n))
+	if cordoned {
+		s.cordons.Set("dev-0", true)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.advertised()
+	}
+}
+
+func BenchmarkLimiterUnchanged(b *testing.B) {
+	benchmarkLimiter(b, false)
+}
+
+func BenchmarkLimiterChanged(b *testing.B) {
+	benchmarkLimiter(b, true)
+}
+
+func benchmarkLimiter(b *testing.B, changed bool) {
+	n := *benchDevices
+	c := newDeviceCache()
+	devs := benchmarkDevices(n)
+	c.Set(devs)
+	l := &updateLimiter{conf: &RateConfig{MinInterval: time.Second, UrgentUnhealthy: true}}
+	api, _ := c.APIList()
+	l.update(api, time.Now())
+	l.sent(api, time.Now())
+
+	lists := [][]*pluginapi.Device{api}
+	if changed {
+		c.Set(flipped(devs, 0))
+		flippedAPI, _ := c.APIList()
+		lists = append(lists, flippedAPI)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.update(lists[i%len(lists)], time.Now())
+	}
+}
+
+// BenchmarkUpdate measures an update from source to message sent.
+func BenchmarkUpdate(b *testing.B) {
+	n := *benchDevices
+	s := newResourceState(Config{ResourceName: "example.com/bench"})
+	devs := benchmarkDevices(n)
+	s.cache.Set(devs)
+	l := &updateLimiter{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		devs = flipped(devs, i%n)
+		s.cache.Set(devs)
+		api, _ := s.advertised()
+		if !l.update(api, time.Now()) {
+			b.Fatal("change not found")
+		}
+		if _, err := (&pluginapi.ListAndWatchResponse{Devices: api}).Marshal(); err != nil {
+			b.Fatal(err)
+		}
+		l.sent(api, time.Now())
+	}
+}
+
+func BenchmarkRegistrySetHealth(b *testing.B) {
+	n := *benchDevices
+	r := NewRegistry(benchmarkDevices(n)...)
+	c := newDeviceCache()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		health := pluginapi.Unhealthy
+		if i/n%2 == 1 {
+			health = pluginapi.Healthy
+		}
+		r.SetHealth(fmt.Sprintf("dev-%d", i%n), health)
+		c.Set(r.List())
+	}
+}
+
+func BenchmarkAllocate(b *testing.B) {
+	n := *benchDevices
+	p := newDevicePlugin(Config{ResourceName: "example.com/bench"}, nil)
+	p.state.cache.Set(benchmarkDevices(n))
+	req := &pluginapi.AllocateRequest{}
+	for i := 0; i < 8; i++ {
+		req.ContainerRequests = append(req.ContainerRequests, &pluginapi.ContainerAllocateRequest{DevicesIDs: []string{fmt.Sprintf("dev-%d", n-1-i)}})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Allocate(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// CDIVersion is the version of CDI specs written.
+const CDIVersion = "0.5.0"
+
+// CDIAnnotationPrefix is the prefix of annotations, in which runtimes find
+// CDI devices to inject.
+const CDIAnnotationPrefix = "cdi.k8s.io/"
+
+// CDIConfig writes a CDI spec of advertised devices, so that runtimes can
+// inject them by CDI device names returned in Allocate annotations.
+type CDIConfig struct {
+	// Dir is where spec is written, /etc/cdi by default.
+	Dir string
+	// Kind is vendor/class of devices, the resource name by default.
+	Kind string
+	// Format is json or yaml, json by default.
+	Format string
+	// EditsFunc returns what to inject into containers for a device.
+	EditsFunc func(*Device) (*CDIEdits, error)
+}
+
+// CDISpec is a CDI spec, see
+// https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+type CDISpec struct {
+	Version string      `json:"cdiVersion"`
+	Kind    string      `json:"kind"`
+	Devices []CDIDevice `json:"devices"`
+}
+
+type CDIDevice struct {
+	Name           string   `json:"name"`
+	ContainerEdits CDIEdits `json:"containerEdits"`
+}
+
+// CDIEdits are changes made to containers.
+type CDIEdits struct {
+	// Env are in form of KEY=VALUE.
+	Env         []string         `json:"env,omitempty"`
+	DeviceNodes []*CDIDeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []*CDIMount      `json:"mounts,omitempty"`
+	Hooks       []*CDIHook       `json:"hooks,omitempty"`
+}
+
+type CDIDeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+type CDIMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Options       []string `json:"options,omitempty"`
+}
+
+type CDIHook struct {
+	// HookName is an OCI hook, such as createContainer.
+	HookName string   `json:"hookName"`
+	Path     string   `json:"path"`
+	Args     []string `json:"args,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	Timeout  *int     `json:"timeout,omitempty"`
+}
+
+var (
+	cdiKindRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*/[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	cdiNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+)
+
+func (c *CDIConfig) dir() string {
+	if c.Dir == "" {
+		return "/etc/cdi"
+	}
+	return c.Dir
+}
+
+func (c *CDIConfig) kind(resourceName string) string {
+	if c.Kind == "" {
+		return resourceName
+	}
+	return c.Kind
+}
+
+func (c *CDIConfig) validate(resourceName string) error {
+	if kind := c.kind(resourceName); !cdiKindRegexp.MatchString(kind) {
+		return fmt.Errorf("%s is not a valid CDI kind", kind)
+	}
+	if c.Format != "" && c.Format != "json" && c.Format != "yaml" {
+		return fmt.Errorf("unknown CDI spec format %s", c.Format)
+	}
+	if c.EditsFunc == nil {
+		return fmt.Errorf("CDI edits func cannot be empty")
+	}
+	return nil
+}
+
+// specPath returns where spec of kind is written, one file per kind.
+func (c *CDIConfig) specPath(kind string) string {
+	ext := c.Format
+	if ext == "" {
+		ext = "json"
+	}
+	return filepath.Join(c.dir(), strings.Replace(kind, "/", "-", 1)+"."+ext)
+}
+
+// writeSpec writes spec of devs, and returns IDs of devices in it, which
+// is nil if spec is not written. Devices with names invalid in CDI or whose
+// edits fail are left out of spec, and reported in error after spec is
+// written.
+func (c *CDIConfig) writeSpec(resourceName string, devs []*Device) (map[string]bool, error) {
+	kind := c.kind(resourceName)
+	spec := CDISpec{Version: CDIVersion, Kind: kind, Devices: []CDIDevice{}}
+	names := make(map[string]bool, len(devs))
+	var failed []string
+	for _, d := range devs {
+		if !cdiNameRegexp.MatchString(d.ID) {
+			failed = append(failed, fmt.Sprintf("%s: invalid CDI device name", d.ID))
+			continue
+		}
+		edits, err := c.EditsFunc(d)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", d.ID, err))
+			continue
+		}
+		if edits == nil {
+			edits = &CDIEdits{}
+		}
+		spec.Devices = append(spec.Devices, CDIDevice{Name: d.ID, ContainerEdits: *edits})
+		names[d.ID] = true
+	}
+	sort.Slice(spec.Devices, func(i, j int) bool { return spec.Devices[i].Name < spec.Devices[j].Name })
+
+	var data []byte
+	var err error
+	if c.Format == "yaml" {
+		data, err = yaml.Marshal(spec)
+	} else {
+		data, err = json.MarshalIndent(spec, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Spec is written atomically, so that runtimes never read a partial
+	// spec.
+	if err = writeFileAtomic(c.specPath(kind), data, 0644); err != nil {
+		return nil, err
+	}
+
+	if len(failed) > 0 {
+		return names, fmt.Errorf("devices left out of CDI spec: %s", strings.Join(failed, "; "))
+	}
+	return names, nil
+}
+
+// cdiSpec writes CDI spec of a resource on every update, and keeps which
+// devices are in it.
+type cdiSpec struct {
+	conf         *CDIConfig
+	resourceName string
+
+	lock sync.RWMutex
+	// names are IDs of devices in spec last written.
+	names map[string]bool
+	// leftOut are devices not in spec, which are advertised Unhealthy, since
+	// runtimes could not resolve their names.
+	leftOut []string
+}
+
+func newCDISpec(resourceName string, conf *CDIConfig) *cdiSpec {
+	return &cdiSpec{conf: conf, resourceName: resourceName, names: map[string]bool{}}
+}
+
+// write writes spec of devs. If spec cannot be written, the spec written
+// before stays.
+func (s *cdiSpec) write(devs []*Device) {
+	names, err := s.conf.writeSpec(s.resourceName, devs)
+	if err != nil {
+		log.Println("Could not write CDI spec:", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if names != nil {
+		s.names = names
+	}
+	var leftOut []string
+	for _, d := range devs {
+		if !s.names[d.ID] {
+			leftOut = append(leftOut, d.ID)
+		}
+	}
+	s.leftOut = leftOut
+}
+
+// Has returns whether device with id is in spec.
+func (s *cdiSpec) Has(id string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.names[id]
+}
+
+// LeftOut returns devices not in spec, which shall not be changed.
+func (s *cdiSpec) LeftOut() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.leftOut
+}
+
+// annotate adds CDI names of ids in spec to resp.
+func (s *cdiSpec) annotate(ids []string, resp *pluginapi.ContainerAllocateResponse) {
+	kind := s.conf.kind(s.resourceName)
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if s.Has(id) {
+			names = append(names, kind+"="+id)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	if resp.Annotations == nil {
+		resp.Annotations = map[string]string{}
+	}
+	// Annotation key shall be unique per plugin, and its name part no
+	// longer than 63 characters.
+	key := strings.NewReplacer("/", "_", ".", "_").Replace(kind)
+	if len(key) > 63 {
+		key = strings.TrimRight(key[:63], "_.-")
+	}
+	resp.Annotations[CDIAnnotationPrefix+key] = strings.Join(names, ",")
+}
+
+// CDIEditsFor converts what an allocate response injects into CDI edits,
+// for plugins moving from DeviceSpec and Mount lists to CDI.
+func CDIEditsFor(resp *pluginapi.ContainerAllocateResponse) *CDIEdits {
+	edits := &CDIEdits{}
+	keys := make([]string, 0, len(resp.Envs))
+	for k := range resp.Envs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		edits.Env = append(edits.Env, k+"="+resp.Envs[k])
+	}
+
+	for _, d := range resp.Devices {
+		edits.DeviceNodes = append(edits.DeviceNodes, &CDIDeviceNode{Path: d.ContainerPath, HostPath: d.HostPath, Permissions: d.Permissions})
+	}
+	for _, m := range resp.Mounts {
+		options := []string{"bind", "rw"}
+		if m.ReadOnly {
+			options = []string{"bind", "ro"}
+		}
+		edits.Mounts = append(edits.Mounts, &CDIMount{HostPath: m.HostPath, ContainerPath: m.ContainerPath, Options: options})
+	}
+	return edits
+}
@@ -0,0 +1,190 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func testCDIConfig(t *testing.T) *CDIConfig {
+	dir, err := ioutil.TempDir("", "cdi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &CDIConfig{
+		Dir: dir,
+		EditsFunc: func(d *Device) (*CDIEdits, error) {
+			if d.Attrs["broken"] != "" {
+				return nil, fmt.Errorf("no device node")
+			}
+			return &CDIEdits{DeviceNodes: []*CDIDeviceNode{{Path: "/dev/" + d.ID}}}, nil
+		},
+	}
+}
+
+func TestCDIWriteSpec(t *testing.T) {
+	devs := []*Device{
+		{ID: "gpu-1"},
+		{ID: "gpu-0"},
+		{ID: "gpu 2"},
+		{ID: "gpu-3", Attrs: map[string]string{"broken": "true"}},
+	}
+	for _, format := range []string{"", "json", "yaml"} {
+		t.Run("format "+format, func(t *testing.T) {
+			conf := testCDIConfig(t)
+			conf.Format = format
+			names, err := conf.writeSpec("example.com/gpu", devs)
+			if want := "devices left out of CDI spec: gpu 2: invalid CDI device name; gpu-3: no device node"; err == nil || err.Error() != want {
+				t.Errorf("got error %v, want %s", err, want)
+			}
+			if want := map[string]bool{"gpu-0": true, "gpu-1": true}; !reflect.DeepEqual(names, want) {
+				t.Errorf("got devices in spec %v, want %v", names, want)
+			}
+
+			ext := format
+			if ext == "" {
+				ext = "json"
+			}
+			data, err := ioutil.ReadFile(filepath.Join(conf.Dir, "example.com-gpu."+ext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var spec CDISpec
+			if format == "yaml" {
+				err = yaml.Unmarshal(data, &spec)
+			} else {
+				err = json.Unmarshal(data, &spec)
+			}
+			if err != nil {
+				t.Fatalf("invalid spec %s: %v", data, err)
+			}
+			if spec.Version != CDIVersion || spec.Kind != "example.com/gpu" {
+				t.Errorf("got spec version %s of kind %s", spec.Version, spec.Kind)
+			}
+			var got []string
+			for _, d := range spec.Devices {
+				got = append(got, d.Name+" "+d.ContainerEdits.DeviceNodes[0].Path)
+			}
+			if want := []string{"gpu-0 /dev/gpu-0", "gpu-1 /dev/gpu-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got devices %v in spec, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCDIAnnotate(t *testing.T) {
+	long := "example.com/" + strings.Repeat("a", 60)
+	for _, tc := range []struct {
+		kind string
+		ids  []string
+		want map[string]string
+	}{
+		{
+			ids:  []string{"gpu-1", "gpu-0"},
+			want: map[string]string{"cdi.k8s.io/example_com_gpu": "example.com/gpu=gpu-1,example.com/gpu=gpu-0"},
+		},
+		{
+			kind: "vendor.io/class",
+			ids:  []string{"gpu-0", "gpu-9"},
+			want: map[string]string{"cdi.k8s.io/vendor_io_class": "vendor.io/class=gpu-0"},
+		},
+		{
+			kind: long,
+			ids:  []string{"gpu-0"},
+			want: map[string]string{"cdi.k8s.io/example_com_" + strings.Repeat("a", 51): long + "=gpu-0"},
+		},
+		{ids: []string{"gpu-9"}},
+	} {
+		conf := testCDIConfig(t)
+		conf.Kind = tc.kind
+		s := newCDISpec("example.com/gpu", conf)
+		s.write([]*Device{{ID: "gpu-0"}, {ID: "gpu-1"}})
+
+		resp := &pluginapi.ContainerAllocateResponse{}
+		s.annotate(tc.ids, resp)
+		if !reflect.DeepEqual(resp.Annotations, tc.want) {
+			t.Errorf("kind %q: got annotations %v of %v, want %v", tc.kind, resp.Annotations, tc.ids, tc.want)
+		}
+	}
+}
+
+// TestCDILeftOut checks that devices left out of spec are advertised
+// Unhealthy, and that spec is kept if it cannot be written.
+func TestCDILeftOut(t *testing.T) {
+	conf := testCDIConfig(t)
+	s := newResourceState(Config{ResourceName: "example.com/gpu", CDI: conf})
+	health := func() string {
+		devs, _ := s.advertised()
+		var got []string
+		for _, d := range devs {
+			got = append(got, d.ID+" "+d.Health)
+		}
+		return strings.Join(got, ", ")
+	}
+	// set updates devices like feed does.
+	set := func(devs []*Device) {
+		s.cache.beforeSet(devs)
+		s.cache.Set(devs)
+	}
+
+	set([]*Device{
+		{ID: "gpu-0", Health: pluginapi.Healthy},
+		{ID: "gpu-1", Health: pluginapi.Healthy, Attrs: map[string]string{"broken": "true"}},
+	})
+	if got, want := s.cdi.LeftOut(), []string{"gpu-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got left out %v, want %v", got, want)
+	}
+	if got, want := health(), "gpu-0 Healthy, gpu-1 Unhealthy"; got != want {
+		t.Errorf("got devices %s, want %s", got, want)
+	}
+
+	// gpu-2 is left out, while the spec written before stays.
+	file := filepath.Join(conf.Dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf.Dir = filepath.Join(file, "cdi")
+	set([]*Device{
+		{ID: "gpu-0", Health: pluginapi.Healthy},
+		{ID: "gpu-2", Health: pluginapi.Healthy},
+	})
+	if !s.cdi.Has("gpu-0") || s.cdi.Has("gpu-2") {
+		t.Errorf("devices in spec are changed without spec written")
+	}
+	if got, want := health(), "gpu-0 Healthy, gpu-2 Unhealthy"; got != want {
+		t.Errorf("got devices %s, want %s", got, want)
+	}
+}
+
+func TestCDIEditsFor(t *testing.T) {
+	edits := CDIEditsFor(&pluginapi.ContainerAllocateResponse{
+		Envs:    map[string]string{"B": "2", "A": "1"},
+		Devices: []*pluginapi.DeviceSpec{{ContainerPath: "/dev/x", HostPath: "/dev/x0", Permissions: "rw"}},
+		Mounts: []*pluginapi.Mount{
+			{ContainerPath: "/lib", HostPath: "/opt/lib", ReadOnly: true},
+			{ContainerPath: "/data", HostPath: "/var/data"},
+		},
+	})
+	want := &CDIEdits{
+		Env:         []string{"A=1", "B=2"},
+		DeviceNodes: []*CDIDeviceNode{{Path: "/dev/x", HostPath: "/dev/x0", Permissions: "rw"}},
+		Mounts: []*CDIMount{
+			{HostPath: "/opt/lib", ContainerPath: "/lib", Options: []string{"bind", "ro"}},
+			{HostPath: "/var/data", ContainerPath: "/data", Options: []string{"bind", "rw"}},
+		},
+	}
+	if !reflect.DeepEqual(edits, want) {
+		got, _ := json.Marshal(edits)
+		wanted, _ := json.Marshal(want)
+		t.Errorf("got edits %s, want %s", got, wanted)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// adminClient returns client and base URL of admin API at address, which is
+// a unix socket path or an http URL.
+func adminClient(address string) (*http.Client, string) {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return &http.Client{Timeout: *timeout}, strings.TrimSuffix(address, "/")
+	}
+	return &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", address)
+			},
+		},
+	}, "http://unix"
+}
+
+// cordon cordons or uncordons ids of resource through admin API, and
+// returns cordoned devices of resource after that.
+func cordon(address, resource string, ids []string, cordoned bool) ([]string, error) {
+	client, base := adminClient(address)
+	path := "/uncordon"
+	if cordoned {
+		path = "/cordon"
+	}
+
+	var resp struct {
+		Cordoned []string `json:"cordoned"`
+	}
+	for _, id := range ids {
+		q := url.Values{"resource": {resource}, "device": {id}}
+		r, err := client.Post(base+path+"?"+q.Encode(), "", nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if r.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s of %s failed: %s", path[1:], id, strings.TrimSpace(string(data)))
+		}
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Cordoned, nil
+}
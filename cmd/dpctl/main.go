@@ -0,0 +1,148 @@
+package main
+
+/**
+  dpctl calls a device plugin on its unix socket like kubelet does.
+
+    dpctl [-s socket] [-o table|json] options
+    dpctl [-s socket] [-o table|json] watch
+    dpctl [-s socket] [-o table|json] allocate <id>...
+    dpctl [-s socket] [-o table|json] prestart <id>...
+    dpctl [-admin address] [-o table|json] cordon <resource> <id>...
+    dpctl [-admin address] [-o table|json] uncordon <resource> <id>...
+
+  cordon and uncordon call admin API of plugin, served on a unix socket
+  path or an http URL.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"deviceplugin"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var (
+	socket  = flag.String("s", "", "socket of device plugin, relative to "+pluginapi.DevicePluginPath+" if not absolute")
+	output  = flag.String("o", "table", "output format, table or json")
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout of options and allocate calls")
+	admin   = flag.String("admin", "", "admin API of plugin, a unix socket path or an http URL")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dpctl [flags] options|watch|allocate <id>...|prestart <id>...|cordon <resource> <id>...|uncordon <resource> <id>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var p printer
+	switch *output {
+	case "table":
+		p = newTablePrinter(os.Stdout)
+	case "json":
+		p = newJSONPrinter(os.Stdout)
+	default:
+		return fmt.Errorf("unknown output format %s", *output)
+	}
+
+	if cmd := args[0]; cmd == "cordon" || cmd == "uncordon" {
+		if len(args) < 3 || *admin == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		cordoned, err := cordon(*admin, args[1], args[2:], cmd == "cordon")
+		if err != nil {
+			return err
+		}
+		return p.Cordoned(args[1], cordoned)
+	}
+	if *socket == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := *socket
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(pluginapi.DevicePluginPath, path)
+	}
+	conn, err := deviceplugin.Dial(path)
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", path, err)
+	}
+	defer conn.Close()
+	client := pluginapi.NewDevicePluginClient(conn)
+
+	cmd, ids := args[0], args[1:]
+	switch cmd {
+	case "options":
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		resp, err := client.GetDevicePluginOptions(ctx, &pluginapi.Empty{})
+		if err != nil {
+			return err
+		}
+		return p.Options(resp)
+	case "watch":
+		return watch(client, p)
+	case "allocate":
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		resp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+		})
+		if err != nil {
+			return err
+		}
+		return p.Allocate(resp)
+	case "prestart":
+		ctx, cancel := context.WithTimeout(context.Background(), pluginapi.KubeletPreStartContainerRPCTimeoutInSecs*time.Second)
+		defer cancel()
+		if _, err := client.PreStartContainer(ctx, &pluginapi.PreStartContainerRequest{DevicesIDs: ids}); err != nil {
+			return err
+		}
+		return p.PreStart(ids)
+	default:
+		return fmt.Errorf("unknown command %s", cmd)
+	}
+}
+
+func watch(client pluginapi.DevicePluginClient, p printer) error {
+	stream, err := client.ListAndWatch(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		return err
+	}
+
+	var last []*pluginapi.Device
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err = p.Update(resp.Devices, diffDevices(last, resp.Devices)); err != nil {
+			return err
+		}
+		last = resp.Devices
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+type printer interface {
+	Options(*pluginapi.DevicePluginOptions) error
+	Update(devices []*pluginapi.Device, diff []deviceChange) error
+	Allocate(*pluginapi.AllocateResponse) error
+	PreStart(ids []string) error
+	Cordoned(resource string, ids []string) error
+}
+
+// deviceChange is a device added ("+"), removed ("-") or changed ("~")
+// since last update.
+type deviceChange struct {
+	Op     string            `json:"op"`
+	Device *pluginapi.Device `json:"device"`
+	// Health is the previous health of a changed device.
+	Health string `json:"previousHealth,omitempty"`
+}
+
+func diffDevices(last, current []*pluginapi.Device) []deviceChange {
+	old := make(map[string]*pluginapi.Device, len(last))
+	for _, d := range last {
+		old[d.ID] = d
+	}
+
+	var diff []deviceChange
+	for _, d := range current {
+		o, ok := old[d.ID]
+		delete(old, d.ID)
+		switch {
+		case !ok:
+			diff = append(diff, deviceChange{Op: "+", Device: d})
+		case o.Health != d.Health || topology(o) != topology(d):
+			diff = append(diff, deviceChange{Op: "~", Device: d, Health: o.Health})
+		}
+	}
+	for _, d := range last {
+		if _, ok := old[d.ID]; ok {
+			diff = append(diff, deviceChange{Op: "-", Device: d})
+		}
+	}
+	return diff
+}
+
+func topology(d *pluginapi.Device) string {
+	if d.Topology == nil {
+		return ""
+	}
+	nodes := make([]string, 0, len(d.Topology.Nodes))
+	for _, n := range d.Topology.Nodes {
+		nodes = append(nodes, fmt.Sprint(n.ID))
+	}
+	return strings.Join(nodes, ",")
+}
+
+type tablePrinter struct {
+	w *tabwriter.Writer
+}
+
+func newTablePrinter(w io.Writer) *tablePrinter {
+	return &tablePrinter{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+}
+
+func (p *tablePrinter) Options(o *pluginapi.DevicePluginOptions) error {
+	fmt.Fprintln(p.w, "OPTION\tVALUE")
+	fmt.Fprintf(p.w, "PreStartRequired\t%v\n", o.PreStartRequired)
+	fmt.Fprintf(p.w, "GetPreferredAllocationAvailable\t%v\n", o.GetPreferredAllocationAvailable)
+	return p.w.Flush()
+}
+
+func (p *tablePrinter) Update(devices []*pluginapi.Device, diff []deviceChange) error {
+	fmt.Fprintf(p.w, "--- %s: %d devices, %d changes\n", time.Now().Format(time.RFC3339), len(devices), len(diff))
+	if len(diff) == 0 {
+		return p.w.Flush()
+	}
+
+	fmt.Fprintln(p.w, "\tID\tHEALTH\tNUMA")
+	for _, c := range diff {
+		health := c.Device.Health
+		if c.Op == "~" && c.Health != health {
+			health = c.Health + " -> " + health
+		}
+		fmt.Fprintf(p.w, "%s\t%s\t%s\t%s\n", c.Op, c.Device.ID, health, topology(c.Device))
+	}
+	return p.w.Flush()
+}
+
+func (p *tablePrinter) Allocate(r *pluginapi.AllocateResponse) error {
+	fmt.Fprintln(p.w, "INDEX\tKIND\tCONTAINER\tHOST\tOPTIONS")
+	for i, c := range r.ContainerResponses {
+		for _, k := range sortedKeys(c.Envs) {
+			fmt.Fprintf(p.w, "%d\tenv\t%s\t%s\t\n", i, k, c.Envs[k])
+		}
+		for _, m := range c.Mounts {
+			opts := "rw"
+			if m.ReadOnly {
+				opts = "ro"
+			}
+			fmt.Fprintf(p.w, "%d\tmount\t%s\t%s\t%s\n", i, m.ContainerPath, m.HostPath, opts)
+		}
+		for _, d := range c.Devices {
+			fmt.Fprintf(p.w, "%d\tdevice\t%s\t%s\t%s\n", i, d.ContainerPath, d.HostPath, d.Permissions)
+		}
+		for _, k := range sortedKeys(c.Annotations) {
+			fmt.Fprintf(p.w, "%d\tannotation\t%s\t%s\t\n", i, k, c.Annotations[k])
+		}
+	}
+	return p.w.Flush()
+}
+
+func (p *tablePrinter) PreStart(ids []string) error {
+	fmt.Fprintf(p.w, "PreStartContainer succeeded for %s\n", strings.Join(ids, ","))
+	return p.w.Flush()
+}
+
+func (p *tablePrinter) Cordoned(resource string, ids []string) error {
+	fmt.Fprintf(p.w, "Cordoned devices of %s: %d\n", resource, len(ids))
+	for _, id := range ids {
+		fmt.Fprintln(p.w, id)
+	}
+	return p.w.Flush()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func newJSONPrinter(w io.Writer) *jsonPrinter {
+	return &jsonPrinter{enc: json.NewEncoder(w)}
+}
+
+func (p *jsonPrinter) Options(o *pluginapi.DevicePluginOptions) error {
+	// Generated json tags omit false options.
+	return p.enc.Encode(struct {
+		PreStartRequired                bool `json:"preStartRequired"`
+		GetPreferredAllocationAvailable bool `json:"getPreferredAllocationAvailable"`
+	}{o.PreStartRequired, o.GetPreferredAllocationAvailable})
+}
+
+func (p *jsonPrinter) Update(devices []*pluginapi.Device, diff []deviceChange) error {
+	return p.enc.Encode(struct {
+		Time    time.Time           `json:"time"`
+		Devices []*pluginapi.Device `json:"devices"`
+		Changes []deviceChange      `json:"changes"`
+	}{time.Now(), devices, diff})
+}
+
+func (p *jsonPrinter) Allocate(r *pluginapi.AllocateResponse) error {
+	return p.enc.Encode(r)
+}
+
+func (p *jsonPrinter) PreStart(ids []string) error {
+	return p.enc.Encode(struct {
+		DevicesIDs []string `json:"devicesIDs"`
+	}{ids})
+}
+
+func (p *jsonPrinter) Cordoned(resource string, ids []string) error {
+	return p.enc.Encode(struct {
+		Resource string   `json:"resource"`
+		Cordoned []string `json:"cordoned"`
+	}{resource, ids})
+}
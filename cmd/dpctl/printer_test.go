@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestDiffDevices(t *testing.T) {
+	dev := func(id, health string, nodes ...int64) *pluginapi.Device {
+		d := &pluginapi.Device{ID: id, Health: health}
+		if len(nodes) > 0 {
+			d.Topology = &pluginapi.TopologyInfo{}
+			for _, n := range nodes {
+				d.Topology.Nodes = append(d.Topology.Nodes, &pluginapi.NUMANode{ID: n})
+			}
+		}
+		return d
+	}
+	healthy, unhealthy := pluginapi.Healthy, pluginapi.Unhealthy
+
+	for _, tc := range []struct {
+		name          string
+		last, current []*pluginapi.Device
+		want          string
+	}{
+		{name: "none"},
+		{
+			name:    "first",
+			current: []*pluginapi.Device{dev("gpu-1", healthy), dev("gpu-0", unhealthy, 0)},
+			want:    "+ gpu-1 Healthy; + gpu-0 Unhealthy",
+		},
+		{
+			name:    "unchanged",
+			last:    []*pluginapi.Device{dev("gpu-0", healthy, 0)},
+			current: []*pluginapi.Device{dev("gpu-0", healthy, 0)},
+		},
+		{
+			name:    "health changed",
+			last:    []*pluginapi.Device{dev("gpu-0", healthy), dev("gpu-1", healthy)},
+			current: []*pluginapi.Device{dev("gpu-0", healthy), dev("gpu-1", unhealthy)},
+			want:    "~ gpu-1 Unhealthy was Healthy",
+		},
+		{
+			name:    "topology changed",
+			last:    []*pluginapi.Device{dev("gpu-0", healthy, 0)},
+			current: []*pluginapi.Device{dev("gpu-0", healthy, 0, 1)},
+			want:    "~ gpu-0 Healthy was Healthy",
+		},
+		{
+			name:    "added and removed",
+			last:    []*pluginapi.Device{dev("gpu-2", healthy), dev("gpu-0", healthy), dev("gpu-1", healthy)},
+			current: []*pluginapi.Device{dev("gpu-1", healthy), dev("gpu-3", healthy)},
+			want:    "+ gpu-3 Healthy; - gpu-2 Healthy; - gpu-0 Healthy",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, c := range diffDevices(tc.last, tc.current) {
+				s := fmt.Sprintf("%s %s %s", c.Op, c.Device.ID, c.Device.Health)
+				if c.Health != "" {
+					s += " was " + c.Health
+				}
+				got = append(got, s)
+			}
+			if strings.Join(got, "; ") != tc.want {
+				t.Errorf("got diff %q, want %q", strings.Join(got, "; "), tc.want)
+			}
+		})
+	}
+}
+
+func TestTablePrinterUpdate(t *testing.T) {
+	var out bytes.Buffer
+	p := newTablePrinter(&out)
+	devs := []*pluginapi.Device{
+		{ID: "gpu-0", Health: pluginapi.Unhealthy, Topology: &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: 0}, {ID: 1}}}},
+		{ID: "gpu-1", Health: pluginapi.Healthy},
+	}
+	diff := []deviceChange{
+		{Op: "~", Device: devs[0], Health: pluginapi.Healthy},
+		{Op: "+", Device: devs[1]},
+	}
+	if err := p.Update(devs, diff); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[0], ": 2 devices, 2 changes") {
+		t.Fatalf("got\n%s", out.String())
+	}
+	for i, want := range [][]string{{"ID", "HEALTH", "NUMA"}, {"~", "gpu-0", "Healthy -> Unhealthy", "0,1"}, {"+", "gpu-1", "Healthy"}} {
+		// Columns are separated by two spaces at least.
+		var fields []string
+		for _, f := range strings.Split(lines[i+1], "  ") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		if strings.Join(fields, "|") != strings.Join(want, "|") {
+			t.Errorf("got row %q, want %q", lines[i+1], want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+/**
+  exec-plugin runs a device plugin driven by executables, similar in spirit
+  to CNI. See deviceplugin.ExecConfig for what the commands read and print.
+
+    exec-plugin -resource example.com/dev -socket dev.sock \
+      -list /opt/dev/list [-list-interval 10s] \
+      [-allocate /opt/dev/allocate] [-prestart /opt/dev/prestart] [-dry-run]
+
+  Commands are split on spaces into arguments. With -dry-run, the plugin is
+  not registered to kubelet, changes of devices are printed, and
+  "allocate <id>..." or "prestart <id>..." lines from stdin are run.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"deviceplugin"
+)
+
+var (
+	resourceName = flag.String("resource", "", "resource name to register")
+	socketName   = flag.String("socket", "", "socket name of plugin")
+	pluginDir    = flag.String("plugin-dir", "", "directory of kubelet socket, default to kubelet device plugin path")
+	list         = flag.String("list", "", "command printing devices as JSON")
+	listInterval = flag.Duration("list-interval", 0, "interval to run list command, or keep it running and read lines if 0")
+	allocate     = flag.String("allocate", "", "command printing allocate response as JSON")
+	prestart     = flag.String("prestart", "", "command run before container starts")
+	timeout      = flag.Duration("timeout", 10*time.Second, "timeout of each run of commands")
+	dryRun       = flag.Bool("dry-run", false, "run without kubelet, printing devices and reading commands from stdin")
+)
+
+func main() {
+	flag.Parse()
+	if *list == "" {
+		fmt.Fprintln(os.Stderr, "Error: -list is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conf := deviceplugin.Config{
+		ResourceName: *resourceName,
+		SocketName:   *socketName,
+		PluginDir:    *pluginDir,
+	}
+	deviceplugin.ExecConfig{
+		List:         strings.Fields(*list),
+		ListInterval: *listInterval,
+		Allocate:     strings.Fields(*allocate),
+		PreStart:     strings.Fields(*prestart),
+		Timeout:      *timeout,
+	}.Apply(&conf, nil)
+	if *dryRun {
+		conf.DryRun = &deviceplugin.DryRunConfig{Input: os.Stdin}
+	}
+
+	if err := deviceplugin.Run(conf, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
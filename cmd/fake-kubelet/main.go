@@ -0,0 +1,162 @@
+package main
+
+/**
+  fake-kubelet serves kubelet registration in a directory, keeps ListAndWatch
+  of registered plugins open, and runs a scenario against them. It exits
+  non-zero if any step fails or any plugin misbehaves.
+
+    fake-kubelet -dir /tmp/device-plugins [-scenario scenario.yaml]
+
+  Run plugins with Config.PluginDir set to the same directory.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"deviceplugin/fakekubelet"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var (
+	dir          = flag.String("dir", "/tmp/device-plugins", "directory to create kubelet socket in")
+	scenarioFile = flag.String("scenario", "", "scenario to run, keep running until interrupted if not set")
+)
+
+var start = time.Now()
+
+func logf(format string, args ...interface{}) {
+	fmt.Printf("[%8.3fs] %s\n", time.Since(start).Seconds(), fmt.Sprintf(format, args...))
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		logf("FAILED: %v", err)
+		os.Exit(1)
+	}
+	logf("PASSED")
+}
+
+func run() error {
+	var s *scenario
+	if *scenarioFile != "" {
+		var err error
+		if s, err = loadScenario(*scenarioFile); err != nil {
+			return err
+		}
+	}
+
+	k := fakekubelet.New(*dir)
+	k.Logf = logf
+	if err := k.Start(); err != nil {
+		return err
+	}
+	defer k.Stop()
+
+	if s == nil {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		return k.Err()
+	}
+
+	var resources []string
+	for i, st := range s.Steps {
+		if err := runStep(k, s, st, resources); err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if st.Wait != nil {
+			resources = appendOnce(resources, st.Wait.Resource)
+		}
+		if st.Admit != nil {
+			resources = appendOnce(resources, st.Admit.Resource)
+		}
+	}
+	for _, r := range resources {
+		if _, ok := k.Plugin(r); !ok {
+			return fmt.Errorf("plugin %s is not registered", r)
+		}
+	}
+	return k.Err()
+}
+
+func runStep(k *fakekubelet.Kubelet, s *scenario, st step, resources []string) error {
+	switch {
+	case st.Wait != nil:
+		logf("==> wait for %d healthy devices of %s", st.Wait.Devices, st.Wait.Resource)
+		return waitForDevices(k, st.Wait.Resource, st.Wait.Devices, s.timeout)
+
+	case st.Admit != nil:
+		a := st.Admit
+		logf("==> admit %s/%s with %d %s", a.Pod, a.Container, a.Count, a.Resource)
+		admission, err := k.Admit(a.Resource, a.Pod, a.Container, a.Count)
+		if a.ExpectFailure {
+			if err == nil {
+				return fmt.Errorf("admission succeeded with %v, but failure expected", admission.DeviceIDs)
+			}
+			logf("Admission failed as expected: %v", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		logf("Response: %v", admission.Response)
+		return nil
+
+	case st.Terminate != nil:
+		logf("==> terminate %s", st.Terminate.Pod)
+		k.Terminate(st.Terminate.Pod)
+		return nil
+
+	case st.Restart:
+		logf("==> restart kubelet")
+		if err := k.Restart(); err != nil {
+			return err
+		}
+		for _, r := range resources {
+			if err := waitForDevices(k, r, 0, s.timeout); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		// Sleep is parsed by loadScenario.
+		logf("==> sleep %v", st.sleep)
+		time.Sleep(st.sleep)
+		return nil
+	}
+}
+
+func waitForDevices(k *fakekubelet.Kubelet, resource string, n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	p, err := k.WaitForPlugin(resource, timeout)
+	if err != nil {
+		return err
+	}
+	return p.WaitForDevices(func(devs []*pluginapi.Device) bool {
+		healthy := 0
+		for _, d := range devs {
+			if d.Health == pluginapi.Healthy {
+				healthy++
+			}
+		}
+		return healthy >= n
+	}, time.Until(deadline))
+}
+
+func appendOnce(s []string, v string) []string {
+	for _, e := range s {
+		if e == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// scenario is a list of steps run against plugins, such as:
+//
+//	resource: example.com/dev
+//	timeout: 30s
+//	steps:
+//	- wait: {devices: 2}
+//	- admit: {pod: p1, count: 1}
+//	- admit: {pod: p2, count: 8, expectFailure: true}
+//	- restart: true
+//	- terminate: {pod: p1}
+//	- sleep: 1s
+type scenario struct {
+	// Resource is the default resource of steps.
+	Resource string `json:"resource"`
+	// Timeout of waiting for plugins, 30s by default.
+	Timeout string `json:"timeout"`
+	Steps   []step `json:"steps"`
+
+	timeout time.Duration
+}
+
+// step shall have exactly one action set.
+type step struct {
+	// Wait waits until plugin registers with enough healthy devices.
+	Wait *waitStep `json:"wait,omitempty"`
+	// Admit allocates devices to a container.
+	Admit *admitStep `json:"admit,omitempty"`
+	// Terminate releases devices of a pod.
+	Terminate *terminateStep `json:"terminate,omitempty"`
+	// Restart restarts kubelet, and waits for plugins to register again.
+	Restart bool `json:"restart,omitempty"`
+	// Sleep is a duration to sleep.
+	Sleep string `json:"sleep,omitempty"`
+
+	sleep time.Duration
+}
+
+type waitStep struct {
+	Resource string `json:"resource"`
+	Devices  int    `json:"devices"`
+}
+
+type admitStep struct {
+	Resource      string `json:"resource"`
+	Pod           string `json:"pod"`
+	Container     string `json:"container"`
+	Count         int    `json:"count"`
+	ExpectFailure bool   `json:"expectFailure"`
+}
+
+type terminateStep struct {
+	Pod string `json:"pod"`
+}
+
+func loadScenario(file string) (*scenario, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &scenario{}
+	if err = yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", file, err)
+	}
+
+	s.timeout = 30 * time.Second
+	if s.Timeout != "" {
+		if s.timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+	}
+
+	for i := range s.Steps {
+		if err = s.Steps[i].complete(s.Resource); err != nil {
+			return nil, fmt.Errorf("invalid step %d: %v", i+1, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *step) complete(resource string) error {
+	actions := 0
+	if s.Wait != nil {
+		actions++
+		if s.Wait.Resource == "" {
+			s.Wait.Resource = resource
+		}
+		if s.Wait.Resource == "" {
+			return fmt.Errorf("resource cannot be empty")
+		}
+	}
+	if s.Admit != nil {
+		actions++
+		if s.Admit.Resource == "" {
+			s.Admit.Resource = resource
+		}
+		if s.Admit.Container == "" {
+			s.Admit.Container = "main"
+		}
+		if s.Admit.Resource == "" || s.Admit.Pod == "" || s.Admit.Count <= 0 {
+			return fmt.Errorf("admit needs resource, pod and a positive count")
+		}
+	}
+	if s.Terminate != nil {
+		actions++
+		if s.Terminate.Pod == "" {
+			return fmt.Errorf("pod cannot be empty")
+		}
+	}
+	if s.Restart {
+		actions++
+	}
+	if s.Sleep != "" {
+		actions++
+		d, err := time.ParseDuration(s.Sleep)
+		if err != nil {
+			return fmt.Errorf("invalid sleep: %v", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("sleep cannot be negative")
+		}
+		s.sleep = d
+	}
+
+	if actions != 1 {
+		return fmt.Errorf("exactly one action shall be set, got %d", actions)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name string
+		data string
+		// err is in error of invalid scenario.
+		err string
+	}{
+		{name: "invalid yaml", data: "steps: [", err: "invalid scenario"},
+		{name: "invalid timeout", data: "timeout: 30", err: "invalid timeout"},
+		{name: "invalid sleep", data: "steps:\n- sleep: 1x", err: "invalid step 1: invalid sleep"},
+		{name: "negative sleep", data: "steps:\n- sleep: -1s", err: "invalid step 1: sleep cannot be negative"},
+		{name: "no action", data: "steps:\n- {}", err: "exactly one action shall be set, got 0"},
+		{name: "two actions", data: "resource: example.com/dev\nsteps:\n- restart: true\n  sleep: 1s", err: "exactly one action shall be set, got 2"},
+		{name: "wait without resource", data: "steps:\n- wait: {devices: 1}", err: "resource cannot be empty"},
+		{name: "admit without pod", data: "resource: example.com/dev\nsteps:\n- admit: {count: 1}", err: "admit needs resource, pod and a positive count"},
+		{name: "admit none", data: "resource: example.com/dev\nsteps:\n- admit: {pod: p1}", err: "admit needs resource, pod and a positive count"},
+		{name: "terminate without pod", data: "steps:\n- restart: true\n- terminate: {}", err: "invalid step 2: pod cannot be empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, "scenario.yaml")
+			if err := ioutil.WriteFile(file, []byte(tc.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadScenario(file)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+
+	if _, err := loadScenario(filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("got error %v of missing scenario, want not exist", err)
+	}
+}
+
+func TestLoadScenarioDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "scenario.yaml")
+	data := `resource: example.com/dev
+steps:
+- wait: {devices: 2}
+- admit: {pod: p1, count: 1}
+- admit: {resource: example.com/other, pod: p2, container: side, count: 8, expectFailure: true}
+- restart: true
+- terminate: {pod: p1}
+- sleep: 1500ms
+`
+	if err = ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadScenario(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.timeout != 30*time.Second {
+		t.Errorf("got timeout %v, want 30s", s.timeout)
+	}
+	if len(s.Steps) != 6 {
+		t.Fatalf("got %d steps, want 6", len(s.Steps))
+	}
+	if w := s.Steps[0].Wait; w.Resource != "example.com/dev" || w.Devices != 2 {
+		t.Errorf("got wait %+v", w)
+	}
+	if a := s.Steps[1].Admit; a.Resource != "example.com/dev" || a.Container != "main" || a.ExpectFailure {
+		t.Errorf("got admit %+v", a)
+	}
+	if a := s.Steps[2].Admit; a.Resource != "example.com/other" || a.Container != "side" || !a.ExpectFailure {
+		t.Errorf("got admit %+v", a)
+	}
+	if !s.Steps[3].Restart || s.Steps[4].Terminate.Pod != "p1" {
+		t.Errorf("got steps %+v %+v", s.Steps[3], s.Steps[4])
+	}
+	if s.Steps[5].sleep != 1500*time.Millisecond {
+		t.Errorf("got sleep %v, want 1.5s", s.Steps[5].sleep)
+	}
+}
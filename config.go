@@ -2,6 +2,9 @@ package deviceplugin
 
 import (
 	"fmt"
+	"path"
+	"path/filepath"
+	"time"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/kubernetes/pkg/apis/core/v1/helper"
@@ -12,11 +15,65 @@ type Config struct {
 	//+ required
 	ResourceName string
 	SocketName   string
-	Update       <-chan []*pluginapi.Device
+	// Either Update or Devices is required.
+	Update  <-chan []*pluginapi.Device
+	Devices <-chan []*Device
 
 	//+ optional
-	PreStartFunc PreStartFunc
-	AllocateFunc AllocateFunc
+	// PluginDir is where kubelet socket is, and plugin socket is created.
+	// It defaults to pluginapi.DevicePluginPath.
+	PluginDir string
+	// StateDir keeps state surviving plugin restarts, such as cordoned
+	// devices. State is kept in memory only if it's not set. Kubelet
+	// removes files in PluginDir when it starts, so don't use it.
+	StateDir                string
+	PreStartFunc            PreStartFunc
+	AllocateFunc            AllocateFunc
+	DeviceAllocateFunc      DeviceAllocateFunc
+	PreferredAllocationFunc PreferredAllocationFunc
+	TopologyFunc            TopologyFunc
+	// AllocationPreStartFunc is like PreStartFunc, but also receives what
+	// Allocate responded for the devices. Allocations never getting a
+	// PreStartContainer are forgotten after AllocationTTL, 5m by default.
+	AllocationPreStartFunc AllocationPreStartFunc
+	AllocationTTL          time.Duration
+	// ResponseValidator, if set, checks each response of Allocate, which
+	// fails with a descriptive error instead of container runtime failing
+	// obscurely. ValidateResponse is provided.
+	ResponseValidator ResponseValidator
+	// OwnerSource, if set, tracks containers devices are assigned to every
+	// OwnerInterval, 10s by default. It's kubelet PodResources API on
+	// PodResourcesSocket by default if ReleaseFunc is set.
+	OwnerSource        OwnerSource
+	OwnerInterval      time.Duration
+	PodResourcesSocket string
+	// ReleaseFunc cleans up devices no longer assigned to any container.
+	// Devices are Unhealthy until cleaned up.
+	ReleaseFunc ReleaseFunc
+	// CDI writes a CDI spec of devices, and returns their CDI names in
+	// Allocate annotations if set.
+	CDI *CDIConfig
+	// Reporter reports health transitions of devices to Kubernetes if set.
+	Reporter *ReporterConfig
+	// Inventory publishes device counts as node labels if set.
+	Inventory *InventoryConfig
+	// UpdateRate limits how often devices are sent to kubelet if set.
+	// Devices same as last sent are never sent again anyway.
+	UpdateRate *RateConfig
+	// DryRun, if set, runs plugin without registering it to kubelet, for
+	// bringing up a new device type.
+	DryRun *DryRunConfig
+}
+
+func (c *Config) pluginDir() string {
+	if c.PluginDir == "" {
+		return pluginapi.DevicePluginPath
+	}
+	return c.PluginDir
+}
+
+func (c *Config) kubeletSocket() string {
+	return filepath.Join(c.pluginDir(), path.Base(pluginapi.KubeletSocket))
 }
 
 func (c *Config) Validate() error {
@@ -34,5 +91,41 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("socket cannot be empty")
 	}
 
+	if (c.Update == nil) == (c.Devices == nil) {
+		return fmt.Errorf("exactly one of update and devices shall be set")
+	}
+
+	if c.AllocateFunc != nil && c.DeviceAllocateFunc != nil {
+		return fmt.Errorf("allocate func and device allocate func cannot be both set")
+	}
+
+	if c.PreStartFunc != nil && c.AllocationPreStartFunc != nil {
+		return fmt.Errorf("prestart func and allocation prestart func cannot be both set")
+	}
+
+	if c.CDI != nil {
+		if err := c.CDI.validate(c.ResourceName); err != nil {
+			return err
+		}
+	}
+
+	if c.Reporter != nil {
+		if err := c.Reporter.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.UpdateRate != nil {
+		if err := c.UpdateRate.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Inventory != nil {
+		if err := c.Inventory.validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,249 @@
+package deviceplugin
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Device is a device managed by plugin. Only ID, Health and Topology are
+// sent to kubelet, while Attrs stay with the plugin.
+type Device struct {
+	ID       string
+	Health   string
+	Topology *pluginapi.TopologyInfo
+
+	// Attrs describes the device, such as model, firmware version,
+	// memory size and serial number.
+	Attrs map[string]string
+}
+
+func (d *Device) apiDevice() *pluginapi.Device {
+	return &pluginapi.Device{ID: d.ID, Health: d.Health, Topology: d.Topology}
+}
+
+func fromAPIDevices(devs []*pluginapi.Device) []*Device {
+	converted := make([]*Device, 0, len(devs))
+	for _, d := range devs {
+		converted = append(converted, &Device{ID: d.ID, Health: d.Health, Topology: d.Topology})
+	}
+	return converted
+}
+
+// deviceCache keeps the latest devices reported by source, and notifies
+// watchers when they change.
+type deviceCache struct {
+	lock sync.RWMutex
+
+	synced  bool
+	devices []*Device
+	// apiDevices are devices converted once per update, shared by every
+	// send.
+	apiDevices []*pluginapi.Device
+	// index maps device ID to its position in devices.
+	index    map[string]int
+	history  map[string][]healthEvent
+	watchers map[chan struct{}]struct{}
+	// onHealth, if set, is called with health transitions on each update.
+	onHealth func([]healthTransition, []*Device)
+	// beforeSet, if set, is called with devices from source before they
+	// are set.
+	beforeSet func([]*Device)
+}
+
+func newDeviceCache() *deviceCache {
+	return &deviceCache{
+		index:    map[string]int{},
+		history:  map[string][]healthEvent{},
+		watchers: map[chan struct{}]struct{}{},
+	}
+}
+
+// feed updates cache with devices from source of conf, until stop is closed
+// or source is closed.
+func (c *deviceCache) feed(conf Config, stop <-chan struct{}) {
+	var topologies map[string]*pluginapi.TopologyInfo
+	for {
+		var devs []*Device
+		select {
+		case <-stop:
+			return
+		case updated, ok := <-conf.Update:
+			if !ok {
+				return
+			}
+			devs = fromAPIDevices(updated)
+		case updated, ok := <-conf.Devices:
+			if !ok {
+				return
+			}
+			devs = updated
+		}
+
+		if conf.TopologyFunc != nil {
+			devs, topologies = withTopology(devs, conf.TopologyFunc, topologies)
+		}
+		// Spec is written first, so that runtimes know devices before
+		// kubelet does.
+		if c.beforeSet != nil {
+			c.beforeSet(devs)
+		}
+		transitions := c.Set(devs)
+		// Devices are not logged one by one, since there may be thousands.
+		log.Printf("Update of %s: %d devices, %d changed health", conf.ResourceName, len(devs), len(transitions))
+	}
+}
+
+// Set replaces all devices, and returns health transitions.
+func (c *deviceCache) Set(devs []*Device) []healthTransition {
+	index := make(map[string]int, len(devs))
+	for i, d := range devs {
+		index[d.ID] = i
+	}
+
+	c.lock.Lock()
+	converted := c.convertLocked(devs)
+	transitions := c.recordHealth(devs, index)
+	c.synced = true
+	c.devices = devs
+	c.apiDevices = converted
+	c.index = index
+	c.notifyLocked()
+	c.lock.Unlock()
+
+	if c.onHealth != nil {
+		c.onHealth(transitions, devs)
+	}
+	return transitions
+}
+
+// convertLocked converts devs for kubelet, reusing devices converted before
+// which did not change, so that unchanged devices cost no allocation and
+// are compared by pointer when sent.
+func (c *deviceCache) convertLocked(devs []*Device) []*pluginapi.Device {
+	converted := make([]*pluginapi.Device, len(devs))
+	for i, d := range devs {
+		if j, ok := c.index[d.ID]; ok {
+			if old := c.apiDevices[j]; old.Health == d.Health && old.Topology == d.Topology {
+				converted[i] = old
+				continue
+			}
+		}
+		converted[i] = d.apiDevice()
+	}
+	return converted
+}
+
+// notify notifies watchers that devices shall be sent again.
+func (c *deviceCache) notify() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.notifyLocked()
+}
+
+func (c *deviceCache) notifyLocked() {
+	for w := range c.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// recordHealth records health transitions from current devices to updated
+// devs, and returns them in order of devs.
+func (c *deviceCache) recordHealth(devs []*Device, index map[string]int) []healthTransition {
+	now := time.Now()
+	var transitions []healthTransition
+	for _, d := range devs {
+		t := healthTransition{ID: d.ID, To: d.Health}
+		if i, ok := c.index[d.ID]; ok {
+			if t.From = c.devices[i].Health; t.From == t.To {
+				continue
+			}
+		}
+		transitions = append(transitions, t)
+
+		h := c.history[d.ID]
+		if len(h) >= maxHealthHistory {
+			h = append(h[:0], h[1:]...)
+		}
+		c.history[d.ID] = append(h, healthEvent{Time: now, Health: d.Health})
+	}
+
+	for id := range c.history {
+		if _, ok := index[id]; !ok {
+			delete(c.history, id)
+		}
+	}
+	return transitions
+}
+
+// History returns recent health transitions of device with id.
+func (c *deviceCache) History(id string) []healthEvent {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]healthEvent(nil), c.history[id]...)
+}
+
+// List returns all devices, and false if source has not reported yet.
+func (c *deviceCache) List() ([]*Device, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.devices, c.synced
+}
+
+// APIList returns all devices as sent to kubelet, which shall not be
+// changed, and false if source has not reported yet.
+func (c *deviceCache) APIList() ([]*pluginapi.Device, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.apiDevices, c.synced
+}
+
+// APIListUnhealthy is like APIList, but reports devices with ids Unhealthy.
+func (c *deviceCache) APIListUnhealthy(ids []string) ([]*pluginapi.Device, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if len(ids) == 0 {
+		return c.apiDevices, c.synced
+	}
+
+	devs := append([]*pluginapi.Device(nil), c.apiDevices...)
+	for _, id := range ids {
+		if i, ok := c.index[id]; ok && devs[i].Health != pluginapi.Unhealthy {
+			copied := *devs[i]
+			copied.Health = pluginapi.Unhealthy
+			devs[i] = &copied
+		}
+	}
+	return devs, c.synced
+}
+
+// Get returns device with id.
+func (c *deviceCache) Get(id string) (*Device, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if i, ok := c.index[id]; ok {
+		return c.devices[i], true
+	}
+	return nil, false
+}
+
+// Watch returns a channel which receives when devices changed. Changes
+// happened before receiving are merged into one.
+func (c *deviceCache) Watch() (<-chan struct{}, func()) {
+	w := make(chan struct{}, 1)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.watchers[w] = struct{}{}
+
+	return w, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.watchers, w)
+	}
+}
@@ -6,16 +6,28 @@ import (
 	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 type AllocateFunc func([]string) (*pluginapi.ContainerAllocateResponse, error)
+
+// DeviceAllocateFunc is like AllocateFunc, but receives the devices with
+// their attributes.
+type DeviceAllocateFunc func([]*Device) (*pluginapi.ContainerAllocateResponse, error)
+
 type PreStartFunc func([]string) error
 
+// PreferredAllocationFunc chooses size devices from available, which shall
+// contain all of mustInclude.
+type PreferredAllocationFunc func(available, mustInclude []string, size int) ([]string, error)
+
 type DevicePlugin interface {
 	Start() error
 	Stop() error
@@ -24,31 +36,61 @@ type DevicePlugin interface {
 type generalDevicePlugin struct {
 	lock sync.Mutex
 
-	resourceName string
-	socket       string
+	resourceName  string
+	socket        string
+	kubeletSocket string
 
-	stop   chan struct{}
-	update <-chan []*pluginapi.Device
+	stop chan struct{}
+	conf Config
+	// state is fed by plugin itself if ownState, or shared with other
+	// plugins of the same config otherwise.
+	state    *resourceState
+	ownState bool
 
 	server *grpc.Server
 
-	preStartFunc PreStartFunc
-	allocateFunc AllocateFunc
+	preStartFunc            PreStartFunc
+	allocateFunc            AllocateFunc
+	deviceAllocateFunc      DeviceAllocateFunc
+	preferredAllocationFunc PreferredAllocationFunc
 }
 
 func ForConfig(conf Config) DevicePlugin {
-	return &generalDevicePlugin{
-		resourceName: conf.ResourceName,
-		socket:       pluginapi.DevicePluginPath + conf.SocketName,
-		update:       conf.Update,
-		preStartFunc: conf.PreStartFunc,
-		allocateFunc: conf.AllocateFunc,
+	return newDevicePlugin(conf, nil)
+}
+
+func newDevicePlugin(conf Config, state *resourceState) *generalDevicePlugin {
+	p := &generalDevicePlugin{
+		resourceName:            conf.ResourceName,
+		socket:                  filepath.Join(conf.pluginDir(), conf.SocketName),
+		kubeletSocket:           conf.kubeletSocket(),
+		conf:                    conf,
+		state:                   state,
+		preStartFunc:            conf.PreStartFunc,
+		allocateFunc:            conf.AllocateFunc,
+		deviceAllocateFunc:      conf.DeviceAllocateFunc,
+		preferredAllocationFunc: conf.PreferredAllocationFunc,
 
 		stop: make(chan struct{}),
 	}
+	if p.state == nil {
+		p.state = newResourceState(conf)
+		p.ownState = true
+	}
+	if conf.AllocationPreStartFunc != nil {
+		p.preStartFunc = func(ids []string) error {
+			return conf.AllocationPreStartFunc(ids, p.state.allocations.Started(ids))
+		}
+	}
+	return p
 }
 
 func (p *generalDevicePlugin) Start() error {
+	if p.ownState {
+		registerState(p.state)
+		p.state.run(p.conf, p.stop)
+	}
+
 	err := p.startServer()
 	if err != nil {
 		return err
@@ -71,51 +113,182 @@ func (p *generalDevicePlugin) Stop() error {
 	p.server.Stop()
 	p.server = nil
 	close(p.stop)
+	if p.ownState {
+		unregisterState(p.state)
+	}
 	return p.cleanup()
 }
 
 func (p *generalDevicePlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
-	return &pluginapi.DevicePluginOptions{PreStartRequired: p.preStartRequired()}, nil
+	return p.options(), nil
 }
 
-func (p *generalDevicePlugin) Allocate(_ context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	resp := &pluginapi.AllocateResponse{}
-	if p.allocateFunc == nil {
+func (p *generalDevicePlugin) GetPreferredAllocation(_ context.Context, r *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	resp := &pluginapi.PreferredAllocationResponse{}
+	if p.preferredAllocationFunc == nil {
 		return resp, nil
 	}
 
 	for _, creq := range r.ContainerRequests {
-		cresp, err := p.allocateFunc(creq.DevicesIDs)
+		ids, err := p.preferredAllocationFunc(creq.AvailableDeviceIDs, creq.MustIncludeDeviceIDs, int(creq.AllocationSize))
+		if err != nil {
+			return &pluginapi.PreferredAllocationResponse{}, err
+		}
+		resp.ContainerResponses = append(resp.ContainerResponses, &pluginapi.ContainerPreferredAllocationResponse{DeviceIDs: ids})
+	}
+	return resp, nil
+}
+
+func (p *generalDevicePlugin) Allocate(_ context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	resp := &pluginapi.AllocateResponse{}
+	for _, creq := range r.ContainerRequests {
+		cresp, err := p.allocate(creq.DevicesIDs)
+		p.state.ledger.Record(creq.DevicesIDs, err)
 		if err != nil {
 			return &pluginapi.AllocateResponse{}, err
 		}
+		if p.state.allocations != nil {
+			p.state.allocations.Put(creq.DevicesIDs, cresp)
+		}
 		resp.ContainerResponses = append(resp.ContainerResponses, cresp)
 	}
 	return resp, nil
 }
 
+func (p *generalDevicePlugin) allocate(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	devs, err := p.allocatable(ids)
+	if err != nil {
+		return nil, err
+	}
+	// Devices not released yet are released before they are set up for the
+	// new container, which release would undo.
+	if p.state.releaser != nil {
+		if err = p.state.releaser.releaseAllocated(ids); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := p.allocateDevices(ids, devs)
+	if err == nil && p.conf.ResponseValidator != nil {
+		if verr := p.conf.ResponseValidator(resp); verr != nil {
+			log.Printf("Invalid allocate response for devices %v: %v", ids, verr)
+			return nil, status.Errorf(codes.Internal, "invalid allocate response for devices %v: %v", ids, verr)
+		}
+	}
+	if err == nil && p.state.releaser != nil {
+		err = p.state.releaser.allocated(ids)
+	}
+	if err != nil || p.state.cdi == nil {
+		return resp, err
+	}
+	if resp == nil {
+		resp = &pluginapi.ContainerAllocateResponse{}
+	}
+	p.state.cdi.annotate(ids, resp)
+	return resp, nil
+}
+
+// allocatable returns devices of ids, if all of them can be allocated.
+func (p *generalDevicePlugin) allocatable(ids []string) ([]*Device, error) {
+	devs := make([]*Device, 0, len(ids))
+	for _, id := range ids {
+		d, ok := p.state.cache.Get(id)
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown device %s", id)
+		}
+		if _, cordoned := p.state.cordons.Get(id); cordoned {
+			return nil, status.Errorf(codes.FailedPrecondition, "device %s is cordoned", id)
+		}
+		if p.state.cdi != nil && !p.state.cdi.Has(id) {
+			// Runtime would fail on a CDI name it cannot resolve.
+			return nil, status.Errorf(codes.FailedPrecondition, "device %s is not in CDI spec", id)
+		}
+		devs = append(devs, d)
+	}
+	return devs, nil
+}
+
+func (p *generalDevicePlugin) allocateDevices(ids []string, devs []*Device) (*pluginapi.ContainerAllocateResponse, error) {
+	switch {
+	case p.allocateFunc != nil:
+		return p.allocateFunc(ids)
+	case p.deviceAllocateFunc != nil:
+		return p.deviceAllocateFunc(devs)
+	default:
+		// Kubelet expects a response for each container.
+		return &pluginapi.ContainerAllocateResponse{}, nil
+	}
+}
+
 func (p *generalDevicePlugin) ListAndWatch(_ *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
 	log.Println("ListAndWatch")
+	return p.watch(s.Context().Done(), func(devs []*pluginapi.Device) error {
+		if err := s.Send(&pluginapi.ListAndWatchResponse{Devices: devs}); err != nil {
+			log.Println("Could not send devices:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// watch calls send with devices whenever they change as UpdateRate allows,
+// until plugin stops or done is closed.
+func (p *generalDevicePlugin) watch(done <-chan struct{}, send func([]*pluginapi.Device) error) error {
+	changed, cancel := p.state.cache.Watch()
+	defer cancel()
+	limiter := &updateLimiter{conf: p.conf.UpdateRate}
+	// wait fires when pending change shall be sent.
+	var wait <-chan time.Time
+
 	for {
+		if devs, synced := p.state.advertised(); synced {
+			wait = nil
+			if limiter.update(devs, time.Now()) {
+				if d := limiter.delay(time.Now()); d > 0 {
+					wait = time.After(d)
+				} else if err := sendPending(send, limiter); err != nil {
+					return err
+				}
+			}
+		}
+
 		select {
 		case <-p.stop:
 			return nil
-		case updated := <-p.update:
-			log.Println("Update: ", updated)
-			err := s.Send(&pluginapi.ListAndWatchResponse{Devices: updated})
-			if err != nil {
-				log.Panicln("ERROR", err)
+		case <-done:
+			return nil
+		case <-changed:
+		case <-wait:
+			if err := sendPending(send, limiter); err != nil {
+				return err
 			}
+			wait = nil
 		}
 	}
 }
 
+func sendPending(send func([]*pluginapi.Device) error, limiter *updateLimiter) error {
+	devs := limiter.pending
+	if err := send(devs); err != nil {
+		return err
+	}
+	limiter.sent(devs, time.Now())
+	return nil
+}
+
 func (p *generalDevicePlugin) PreStartContainer(_ context.Context, r *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
 	resp := &pluginapi.PreStartContainerResponse{}
 	if p.preStartFunc == nil {
 		return resp, nil
 	}
 
+	if p.state.owners != nil && len(r.DevicesIDs) > 0 {
+		// Owners are not refreshed here, which may take longer than kubelet
+		// waits.
+		if o, ok := p.state.owners.Get(r.DevicesIDs[0]); ok {
+			log.Printf("PreStartContainer of %s for %v", o, r.DevicesIDs)
+		}
+	}
 	err := p.preStartFunc(r.DevicesIDs)
 	return resp, err
 }
@@ -137,7 +310,7 @@ func (p *generalDevicePlugin) startServer() error {
 	go p.server.Serve(sock)
 
 	// Wait for server to start by launching a blocking connexion
-	conn, err := dial(p.socket)
+	conn, err := Dial(p.socket)
 	if err != nil {
 		return err
 	}
@@ -148,7 +321,7 @@ func (p *generalDevicePlugin) startServer() error {
 }
 
 func (p *generalDevicePlugin) register() error {
-	conn, err := dial(pluginapi.KubeletSocket)
+	conn, err := Dial(p.kubeletSocket)
 	if err != nil {
 		return err
 	}
@@ -159,10 +332,11 @@ func (p *generalDevicePlugin) register() error {
 		Version:      pluginapi.Version,
 		Endpoint:     path.Base(p.socket),
 		ResourceName: p.resourceName,
-		Options:      &pluginapi.DevicePluginOptions{PreStartRequired: p.preStartRequired()},
+		Options:      p.options(),
 	}
 
 	_, err = client.Register(context.Background(), reqt)
+	p.state.setRegistered(err)
 	if err != nil {
 		return err
 	}
@@ -170,12 +344,16 @@ func (p *generalDevicePlugin) register() error {
 	return nil
 }
 
-func (p *generalDevicePlugin) preStartRequired() bool {
-	return p.preStartFunc != nil
+func (p *generalDevicePlugin) options() *pluginapi.DevicePluginOptions {
+	return &pluginapi.DevicePluginOptions{
+		PreStartRequired:                p.preStartFunc != nil,
+		GetPreferredAllocationAvailable: p.preferredAllocationFunc != nil,
+	}
 }
 
-// dial establishes the gRPC communication with the registered device plugin.
-func dial(unixSocketPath string) (*grpc.ClientConn, error) {
+// Dial establishes the gRPC communication with the device plugin or kubelet
+// listening on unixSocketPath.
+func Dial(unixSocketPath string) (*grpc.ClientConn, error) {
 	return grpc.Dial(unixSocketPath, grpc.WithInsecure(), grpc.WithBlock(),
 		grpc.WithTimeout(10*time.Second),
 		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
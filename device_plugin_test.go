@@ -0,0 +1,74 @@
+package deviceplugin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestAllocateUnknownDevice(t *testing.T) {
+	p := newDevicePlugin(Config{ResourceName: "example.com/dev"}, nil)
+	p.state.cache.Set([]*Device{{ID: "dev-0", Health: pluginapi.Healthy}})
+
+	_, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"dev-0", "dev-1"}}},
+	})
+	if code := grpc.Code(err); code != codes.InvalidArgument {
+		t.Errorf("got code %v of error %v, want %v", code, err, codes.InvalidArgument)
+	}
+}
+
+func TestCordon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cordon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := Config{ResourceName: "example.com/dev", StateDir: dir}
+	devs := []*Device{{ID: "dev-0", Health: pluginapi.Healthy}, {ID: "dev-1", Health: pluginapi.Healthy}}
+
+	for _, restarted := range []bool{false, true} {
+		p := newDevicePlugin(conf, nil)
+		p.state.cache.Set(devs)
+		if !restarted {
+			if err = p.state.cordon("dev-1", true); err != nil {
+				t.Fatal(err)
+			}
+			if err = p.state.cordon("dev-9", true); err == nil {
+				t.Error("unknown device is cordoned")
+			}
+		}
+
+		advertised, _ := p.state.advertised()
+		health := map[string]string{}
+		for _, d := range advertised {
+			health[d.ID] = d.Health
+		}
+		if health["dev-0"] != pluginapi.Healthy || health["dev-1"] != pluginapi.Unhealthy {
+			t.Errorf("restarted %v: advertised %v, want dev-1 Unhealthy only", restarted, health)
+		}
+		_, err = p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"dev-0", "dev-1"}}},
+		})
+		if code := grpc.Code(err); code != codes.FailedPrecondition {
+			t.Errorf("restarted %v: got code %v of error %v, want %v", restarted, code, err, codes.FailedPrecondition)
+		}
+	}
+
+	p := newDevicePlugin(conf, nil)
+	p.state.cache.Set(devs)
+	if err = p.state.cordon("dev-1", false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"dev-1"}}},
+	})
+	if err != nil {
+		t.Errorf("uncordoned device is not allocated: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package deviceplugintest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"deviceplugin/fakekubelet"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Timeout of waiting for plugin to register, report devices and stop.
+var Timeout = 10 * time.Second
+
+// RunConformance runs each check as a subtest, against a plugin created by
+// factory in a fresh environment.
+func RunConformance(t *testing.T, factory Factory) {
+	checks := []struct {
+		name  string
+		check func(*testing.T, *harness)
+	}{
+		{"InitialDevices", checkInitialDevices},
+		{"Updates", checkUpdates},
+		{"UnknownDevices", checkUnknownDevices},
+		{"PreStart", checkPreStart},
+		{"Reregister", checkReregister},
+		{"ConcurrentAllocate", checkConcurrentAllocate},
+		{"StopCleanup", checkStopCleanup},
+	}
+
+	for _, c := range checks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			h := start(t, factory)
+			defer h.stop()
+			c.check(t, h)
+			if err := h.pluginErr(); err != nil {
+				t.Errorf("Plugin misbehaved: %v", err)
+			}
+		})
+	}
+}
+
+func checkInitialDevices(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+}
+
+func checkUpdates(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+
+	updated := []*pluginapi.Device{
+		{ID: "dev-0", Health: pluginapi.Unhealthy},
+		{ID: "dev-1", Health: pluginapi.Healthy},
+		{ID: "dev-2", Health: pluginapi.Healthy},
+		{ID: "dev-4", Health: pluginapi.Healthy},
+	}
+	h.update <- updated
+	h.waitForDevices(updated)
+}
+
+func checkUnknownDevices(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+
+	for _, ids := range [][]string{{"deviceplugintest-unknown"}, {"dev-0", "deviceplugintest-unknown"}} {
+		if _, err := h.allocate(ids); err == nil {
+			t.Errorf("Allocate of %v succeeded, but unknown devices shall be rejected", ids)
+		}
+	}
+}
+
+func checkPreStart(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	options, err := h.plugin.Client.GetDevicePluginOptions(ctx, &pluginapi.Empty{})
+	if err != nil {
+		t.Fatalf("GetDevicePluginOptions failed: %v", err)
+	}
+	if options.PreStartRequired != h.plugin.Options.PreStartRequired {
+		t.Errorf("PreStartRequired is %v, but registered with %v", options.PreStartRequired, h.plugin.Options.PreStartRequired)
+	}
+	if options.GetPreferredAllocationAvailable != h.plugin.Options.GetPreferredAllocationAvailable {
+		t.Errorf("GetPreferredAllocationAvailable is %v, but registered with %v",
+			options.GetPreferredAllocationAvailable, h.plugin.Options.GetPreferredAllocationAvailable)
+	}
+
+	// Admit calls PreStartContainer if and only if it is required.
+	if _, err = h.kubelet.Admit(h.subject.ResourceName, "pod", "main", 2); err != nil {
+		t.Errorf("Admission failed: %v", err)
+	}
+}
+
+func checkReregister(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+	if _, err := h.kubelet.Admit(h.subject.ResourceName, "pod", "main", 1); err != nil {
+		t.Fatalf("Admission failed: %v", err)
+	}
+
+	if err := h.kubelet.Restart(); err != nil {
+		t.Fatalf("Could not restart kubelet: %v", err)
+	}
+	h.waitForPlugin()
+	h.waitForDevices(h.devices)
+	if _, err := h.kubelet.Admit(h.subject.ResourceName, "pod2", "main", 1); err != nil {
+		t.Errorf("Admission after kubelet restart failed: %v", err)
+	}
+}
+
+func checkConcurrentAllocate(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+
+	const workers, calls = 16, 20
+	errs := make(chan error, workers*calls)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				id := h.devices[(i+j)%len(h.devices)].ID
+				if _, err := h.allocate([]string{id}); err != nil {
+					errs <- fmt.Errorf("Allocate of %s failed: %v", id, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func checkStopCleanup(t *testing.T, h *harness) {
+	h.waitForDevices(h.devices)
+
+	socket := h.plugin.Socket
+	if err := h.stopPlugin(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Socket %s is not removed after plugin stops: %v", socket, err)
+	}
+}
+
+// harness runs a plugin under test with a fake kubelet.
+type harness struct {
+	t       *testing.T
+	dir     string
+	devices []*pluginapi.Device
+	update  chan []*pluginapi.Device
+	kubelet *fakekubelet.Kubelet
+	subject Subject
+	plugin  *fakekubelet.Plugin
+
+	stopCh  chan struct{}
+	done    chan error
+	stopped bool
+	// err is misbehaviour found before plugin stops, after which
+	// ListAndWatch ends as expected.
+	err error
+
+	// logging stops when test ends.
+	lock    sync.Mutex
+	logging bool
+}
+
+func start(t *testing.T, factory Factory) *harness {
+	dir, err := ioutil.TempDir("", "deviceplugintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{
+		t:   t,
+		dir: dir,
+		devices: []*pluginapi.Device{
+			{ID: "dev-0", Health: pluginapi.Healthy},
+			{ID: "dev-1", Health: pluginapi.Healthy},
+			{ID: "dev-2", Health: pluginapi.Healthy},
+			{ID: "dev-3", Health: pluginapi.Healthy},
+		},
+		update:  make(chan []*pluginapi.Device, 16),
+		kubelet: fakekubelet.New(dir),
+		stopCh:  make(chan struct{}),
+		done:    make(chan error, 1),
+		logging: true,
+	}
+	h.update <- h.devices
+	h.kubelet.Logf = h.logf
+	if err = h.kubelet.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	h.subject, err = factory(Env{Dir: dir, Devices: h.devices, Update: h.update})
+	if err != nil {
+		h.stop()
+		t.Fatalf("Could not create plugin: %v", err)
+	}
+	go func() {
+		h.done <- h.subject.Run(h.stopCh)
+	}()
+	h.waitForPlugin()
+	return h
+}
+
+func (h *harness) stop() {
+	if !h.stopped {
+		if err := h.stopPlugin(); err != nil {
+			h.t.Error(err)
+		}
+	}
+	h.kubelet.Stop()
+	os.RemoveAll(h.dir)
+
+	h.lock.Lock()
+	h.logging = false
+	h.lock.Unlock()
+}
+
+// stopPlugin stops plugin, and waits for it to exit.
+func (h *harness) stopPlugin() error {
+	h.err = h.kubelet.Err()
+	h.stopped = true
+	close(h.stopCh)
+	select {
+	case err := <-h.done:
+		if err != nil {
+			return fmt.Errorf("plugin exited with error: %v", err)
+		}
+		return nil
+	case <-time.After(Timeout):
+		return fmt.Errorf("plugin did not exit in %v", Timeout)
+	}
+}
+
+func (h *harness) pluginErr() error {
+	if h.stopped {
+		return h.err
+	}
+	return h.kubelet.Err()
+}
+
+func (h *harness) waitForPlugin() {
+	registered := make(chan error, 1)
+	go func() {
+		var err error
+		h.plugin, err = h.kubelet.WaitForPlugin(h.subject.ResourceName, Timeout)
+		registered <- err
+	}()
+
+	select {
+	case err := <-registered:
+		if err != nil {
+			h.t.Fatal(err)
+		}
+	case err := <-h.done:
+		h.stopped = true
+		h.t.Fatalf("Plugin exited before registration: %v", err)
+	}
+}
+
+func (h *harness) waitForDevices(expected []*pluginapi.Device) {
+	health := make(map[string]string, len(expected))
+	for _, d := range expected {
+		health[d.ID] = d.Health
+	}
+
+	err := h.plugin.WaitForDevices(func(devs []*pluginapi.Device) bool {
+		if len(devs) != len(health) {
+			return false
+		}
+		for _, d := range devs {
+			if h, ok := health[d.ID]; !ok || h != d.Health {
+				return false
+			}
+		}
+		return true
+	}, Timeout)
+	if err != nil {
+		devs, _ := h.plugin.Devices()
+		h.t.Fatalf("%v: got %v, expected %v", err, devs, expected)
+	}
+}
+
+func (h *harness) allocate(ids []string) (*pluginapi.AllocateResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	resp, err := h.plugin.Client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+	})
+	if err == nil && len(resp.ContainerResponses) != 1 {
+		err = fmt.Errorf("got %d responses for 1 container", len(resp.ContainerResponses))
+	}
+	return resp, err
+}
+
+func (h *harness) logf(format string, args ...interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.logging {
+		h.t.Logf(format, args...)
+	}
+}
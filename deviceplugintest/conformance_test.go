@@ -0,0 +1,36 @@
+package deviceplugintest_test
+
+import (
+	"testing"
+
+	"deviceplugin"
+	"deviceplugin/deviceplugintest"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestConformance(t *testing.T) {
+	deviceplugintest.RunConformance(t, func(env deviceplugintest.Env) (deviceplugintest.Subject, error) {
+		return deviceplugintest.ConfigSubject(deviceplugin.Config{
+			ResourceName: "example.com/dev",
+			SocketName:   "dev.sock",
+			PluginDir:    env.Dir,
+			Update:       env.Update,
+		}), nil
+	})
+}
+
+func TestConformanceWithFuncs(t *testing.T) {
+	deviceplugintest.RunConformance(t, func(env deviceplugintest.Env) (deviceplugintest.Subject, error) {
+		return deviceplugintest.ConfigSubject(deviceplugin.Config{
+			ResourceName: "example.com/dev",
+			SocketName:   "dev.sock",
+			PluginDir:    env.Dir,
+			Update:       env.Update,
+			AllocateFunc: func(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+				return &pluginapi.ContainerAllocateResponse{Envs: map[string]string{"DEVICES": ids[0]}}, nil
+			},
+			PreStartFunc: func([]string) error { return nil },
+		}), nil
+	})
+}
@@ -0,0 +1,106 @@
+package deviceplugintest
+
+import (
+	"sync"
+
+	"k8s.io/api/core/v1"
+)
+
+// FakeNodeClient records events, node conditions and labels written by
+// plugins with Config.Reporter or Config.Inventory set.
+type FakeNodeClient struct {
+	lock       sync.Mutex
+	err        error
+	events     []*v1.Event
+	conditions map[string][]v1.NodeCondition
+	labels     map[string]map[string]string
+}
+
+func (c *FakeNodeClient) CreateEvent(event *v1.Event) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.events = append(c.events, event)
+	return nil
+}
+
+func (c *FakeNodeClient) SetNodeCondition(nodeName string, condition v1.NodeCondition) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.conditions == nil {
+		c.conditions = map[string][]v1.NodeCondition{}
+	}
+	conditions := c.conditions[nodeName]
+	for i := range conditions {
+		if conditions[i].Type == condition.Type {
+			conditions[i] = condition
+			return nil
+		}
+	}
+	c.conditions[nodeName] = append(conditions, condition)
+	return nil
+}
+
+func (c *FakeNodeClient) PatchNodeLabels(nodeName string, labels map[string]*string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.labels == nil {
+		c.labels = map[string]map[string]string{}
+	}
+	if c.labels[nodeName] == nil {
+		c.labels[nodeName] = map[string]string{}
+	}
+	for key, value := range labels {
+		if value == nil {
+			delete(c.labels[nodeName], key)
+		} else {
+			c.labels[nodeName][key] = *value
+		}
+	}
+	return nil
+}
+
+// SetErr makes writes fail with err, or succeed if it's nil.
+func (c *FakeNodeClient) SetErr(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.err = err
+}
+
+// Events returns events created.
+func (c *FakeNodeClient) Events() []*v1.Event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*v1.Event(nil), c.events...)
+}
+
+// Condition returns condition of type set on node.
+func (c *FakeNodeClient) Condition(nodeName string, conditionType v1.NodeConditionType) (v1.NodeCondition, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, condition := range c.conditions[nodeName] {
+		if condition.Type == conditionType {
+			return condition, true
+		}
+	}
+	return v1.NodeCondition{}, false
+}
+
+// Labels returns labels of node.
+func (c *FakeNodeClient) Labels(nodeName string) map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	labels := make(map[string]string, len(c.labels[nodeName]))
+	for key, value := range c.labels[nodeName] {
+		labels[key] = value
+	}
+	return labels
+}
@@ -0,0 +1,63 @@
+package deviceplugintest_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"deviceplugin"
+	"deviceplugin/deviceplugintest"
+
+	"k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// TestFakeNodeClient runs a plugin in dry run, reporting to FakeNodeClient.
+func TestFakeNodeClient(t *testing.T) {
+	client := &deviceplugintest.FakeNodeClient{}
+	devices := make(chan []*deviceplugin.Device, 1)
+	sigCh := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- deviceplugin.Run(deviceplugin.Config{
+			ResourceName: "example.com/dev",
+			SocketName:   "dev.sock",
+			Devices:      devices,
+			Reporter:     &deviceplugin.ReporterConfig{Client: client, NodeName: "node-0", ConditionType: "DevHealthy"},
+			Inventory:    &deviceplugin.InventoryConfig{Labeler: client, NodeName: "node-0"},
+			DryRun:       &deviceplugin.DryRunConfig{Output: ioutil.Discard},
+		}, sigCh)
+	}()
+	defer func() {
+		sigCh <- false
+		if err := <-errCh; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	waitFor := func(what string, done func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	condition := func(status v1.ConditionStatus) func() bool {
+		return func() bool {
+			c, ok := client.Condition("node-0", "DevHealthy")
+			return ok && c.Status == status
+		}
+	}
+
+	devices <- []*deviceplugin.Device{{ID: "dev-0", Health: pluginapi.Healthy}, {ID: "dev-1", Health: pluginapi.Healthy}}
+	waitFor("condition True", condition(v1.ConditionTrue))
+	waitFor("labels", func() bool { return client.Labels("node-0")["example.com/dev.healthy"] == "2" })
+
+	devices <- []*deviceplugin.Device{{ID: "dev-0", Health: pluginapi.Healthy}, {ID: "dev-1", Health: pluginapi.Unhealthy}}
+	waitFor("condition False", condition(v1.ConditionFalse))
+	waitFor("event", func() bool { return len(client.Events()) == 1 })
+	if e := client.Events()[0]; e.Reason != "DeviceUnhealthy" || e.InvolvedObject.Name != "node-0" {
+		t.Errorf("got event %s of %s", e.Reason, e.InvolvedObject.Name)
+	}
+	waitFor("labels", func() bool { return client.Labels("node-0")["example.com/dev.healthy"] == "1" })
+}
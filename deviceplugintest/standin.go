@@ -0,0 +1,77 @@
+package deviceplugintest
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"deviceplugin"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// StandIn stands in for a device management daemon called by
+// deviceplugin.Remote. Set Allocate and PreStart to delay or return
+// status errors to simulate failures.
+type StandIn struct {
+	Allocate deviceplugin.AllocateFunc
+	PreStart deviceplugin.PreStartFunc
+
+	lock  sync.Mutex
+	calls int
+}
+
+// Calls returns how many requests are served.
+func (s *StandIn) Calls() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+func (s *StandIn) allocate(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	s.count()
+	if s.Allocate == nil {
+		return &pluginapi.ContainerAllocateResponse{}, nil
+	}
+	return s.Allocate(ids)
+}
+
+func (s *StandIn) preStart(ids []string) error {
+	s.count()
+	if s.PreStart == nil {
+		return nil
+	}
+	return s.PreStart(ids)
+}
+
+func (s *StandIn) count() {
+	s.lock.Lock()
+	s.calls++
+	s.lock.Unlock()
+}
+
+// ListenHTTP serves HTTP/JSON on a local port, and returns its endpoint
+// and func to stop it.
+func (s *StandIn) ListenHTTP() (string, func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	server := &http.Server{Handler: deviceplugin.RemoteHandler(s.allocate, s.preStart)}
+	go server.Serve(l)
+	return "http://" + l.Addr().String(), func() { server.Close() }, nil
+}
+
+// ListenGRPC serves gRPC on a local port, and returns its endpoint and func
+// to stop it.
+func (s *StandIn) ListenGRPC() (string, func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	server := grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(server, deviceplugin.RemoteServer(s.allocate, s.preStart))
+	go server.Serve(l)
+	return "grpc://" + l.Addr().String(), server.Stop, nil
+}
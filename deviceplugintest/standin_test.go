@@ -0,0 +1,98 @@
+package deviceplugintest_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"deviceplugin"
+	"deviceplugin/deviceplugintest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestStandIn(t *testing.T) {
+	for _, transport := range []string{"http", "grpc"} {
+		t.Run(transport, func(t *testing.T) {
+			var lock sync.Mutex
+			var failures []error
+			standIn := &deviceplugintest.StandIn{
+				Allocate: func(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					if len(failures) > 0 {
+						err := failures[0]
+						failures = failures[1:]
+						return nil, err
+					}
+					return &pluginapi.ContainerAllocateResponse{Envs: map[string]string{"DEV": ids[0]}}, nil
+				},
+				PreStart: func([]string) error { return status.Error(codes.FailedPrecondition, "not ready") },
+			}
+			fail := func(errs ...error) {
+				lock.Lock()
+				defer lock.Unlock()
+				failures = errs
+			}
+
+			listen := standIn.ListenHTTP
+			if transport == "grpc" {
+				listen = standIn.ListenGRPC
+			}
+			endpoint, stop, err := listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+			remote, err := deviceplugin.NewRemote(deviceplugin.RemoteConfig{
+				Endpoint:         endpoint,
+				ResourceName:     "example.com/dev",
+				Retries:          1,
+				RetryBackoff:     time.Millisecond,
+				FailureThreshold: 2,
+				OpenDuration:     time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer remote.Close()
+
+			resp, err := remote.Allocate([]string{"dev-0"})
+			if err != nil || resp.Envs["DEV"] != "dev-0" {
+				t.Fatalf("got response %v, error %v", resp, err)
+			}
+			if err = remote.PreStart([]string{"dev-0"}); grpc.Code(err) != codes.FailedPrecondition {
+				t.Errorf("got prestart error %v, want code %v", err, codes.FailedPrecondition)
+			}
+
+			// Unavailable is retried once.
+			fail(status.Error(codes.Unavailable, "busy"))
+			if _, err = remote.Allocate([]string{"dev-0"}); err != nil {
+				t.Errorf("retried allocate failed: %v", err)
+			}
+			if calls := standIn.Calls(); calls != 4 {
+				t.Errorf("stand-in got %d calls, want 4", calls)
+			}
+
+			// Two calls failing after retries open the circuit, which
+			// fails calls without reaching the stand-in.
+			unavailable := status.Error(codes.Unavailable, "down")
+			fail(unavailable, unavailable, unavailable, unavailable)
+			for i := 0; i < 2; i++ {
+				if _, err = remote.Allocate([]string{"dev-0"}); grpc.Code(err) != codes.Unavailable {
+					t.Errorf("got error %v, want code %v", err, codes.Unavailable)
+				}
+			}
+			calls := standIn.Calls()
+			if _, err = remote.Allocate([]string{"dev-0"}); grpc.Code(err) != codes.Unavailable {
+				t.Errorf("got error %v with circuit open, want code %v", err, codes.Unavailable)
+			}
+			if standIn.Calls() != calls {
+				t.Error("call reached stand-in with circuit open")
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Package deviceplugintest checks that device plugins behave as kubelet
+// expects. Run it from tests of your plugin:
+//
+//	func TestConformance(t *testing.T) {
+//		deviceplugintest.RunConformance(t, func(env deviceplugintest.Env) (deviceplugintest.Subject, error) {
+//			return deviceplugintest.ConfigSubject(deviceplugin.Config{
+//				ResourceName: "example.com/dev",
+//				SocketName:   "dev.sock",
+//				PluginDir:    env.Dir,
+//				Update:       env.Update,
+//			}), nil
+//		})
+//	}
+package deviceplugintest
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+
+	"deviceplugin"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Env is what a plugin under test runs with.
+type Env struct {
+	// Dir is where kubelet socket is, and plugin socket shall be created.
+	Dir string
+	// Devices are the initial devices plugin shall advertise.
+	Devices []*pluginapi.Device
+	// Update sends Devices first, then devices plugin shall advertise
+	// after each change.
+	Update <-chan []*pluginapi.Device
+}
+
+// Subject is a plugin under test.
+type Subject struct {
+	ResourceName string
+	// Run runs plugin until stop is closed. It shall register to kubelet
+	// in Env.Dir, and register again when kubelet restarts.
+	Run func(stop <-chan struct{}) error
+}
+
+// Factory creates a plugin under test in env.
+type Factory func(env Env) (Subject, error)
+
+// ConfigSubject runs plugin built on this library with deviceplugin.Run.
+func ConfigSubject(conf deviceplugin.Config) Subject {
+	return Subject{
+		ResourceName: conf.ResourceName,
+		Run: func(stop <-chan struct{}) error {
+			sigCh := make(chan bool, 1)
+			go func() {
+				<-stop
+				sigCh <- false
+			}()
+			return deviceplugin.Run(conf, sigCh)
+		},
+	}
+}
+
+// ServerSubject serves any device plugin server on socketName under dir,
+// and registers it like deviceplugin.Run does.
+func ServerSubject(dir, resourceName, socketName string, server pluginapi.DevicePluginServer) Subject {
+	return Subject{
+		ResourceName: resourceName,
+		Run: func(stop <-chan struct{}) error {
+			return serve(dir, resourceName, socketName, server, stop)
+		},
+	}
+}
+
+func serve(dir, resourceName, socketName string, server pluginapi.DevicePluginServer, stop <-chan struct{}) error {
+	socket := filepath.Join(dir, socketName)
+	kubeletSocket := filepath.Join(dir, path.Base(pluginapi.KubeletSocket))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err = watcher.Add(dir); err != nil {
+		return err
+	}
+
+	if err = os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(s, server)
+	go s.Serve(l)
+	defer os.Remove(socket)
+	defer s.Stop()
+
+	if err = register(kubeletSocket, resourceName, socketName, server); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event := <-watcher.Events:
+			if event.Name == kubeletSocket && event.Op&fsnotify.Create == fsnotify.Create {
+				if err = register(kubeletSocket, resourceName, socketName, server); err != nil {
+					return err
+				}
+			}
+		case err := <-watcher.Errors:
+			log.Println(err)
+		}
+	}
+}
+
+func register(kubeletSocket, resourceName, socketName string, server pluginapi.DevicePluginServer) error {
+	options, err := server.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		return err
+	}
+
+	conn, err := deviceplugin.Dial(kubeletSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = pluginapi.NewRegistrationClient(conn).Register(context.Background(), &pluginapi.RegisterRequest{
+		Version:      pluginapi.Version,
+		Endpoint:     socketName,
+		ResourceName: resourceName,
+		Options:      options,
+	})
+	return err
+}
@@ -0,0 +1,94 @@
+package deviceplugintest_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"deviceplugin/deviceplugintest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// minimalServer is a device plugin server written without this library,
+// advertising devices from update.
+type minimalServer struct {
+	lock    sync.Mutex
+	devices []*pluginapi.Device
+	// changed is closed and replaced when devices change.
+	changed chan struct{}
+}
+
+func newMinimalServer(update <-chan []*pluginapi.Device) *minimalServer {
+	s := &minimalServer{changed: make(chan struct{})}
+	go func() {
+		for devs := range update {
+			s.lock.Lock()
+			s.devices = devs
+			close(s.changed)
+			s.changed = make(chan struct{})
+			s.lock.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *minimalServer) list() ([]*pluginapi.Device, chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.devices, s.changed
+}
+
+func (s *minimalServer) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{PreStartRequired: true}, nil
+}
+
+func (s *minimalServer) ListAndWatch(_ *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) error {
+	for {
+		devs, changed := s.list()
+		if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: devs}); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *minimalServer) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "no preferred allocation")
+}
+
+func (s *minimalServer) Allocate(_ context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	devs, _ := s.list()
+	known := map[string]bool{}
+	for _, d := range devs {
+		known[d.ID] = true
+	}
+	resp := &pluginapi.AllocateResponse{}
+	for _, c := range r.ContainerRequests {
+		for _, id := range c.DevicesIDs {
+			if !known[id] {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown device %s", id)
+			}
+		}
+		resp.ContainerResponses = append(resp.ContainerResponses, &pluginapi.ContainerAllocateResponse{})
+	}
+	return resp, nil
+}
+
+func (s *minimalServer) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
+
+// TestConformanceServerSubject runs checks against a server not built on
+// this library.
+func TestConformanceServerSubject(t *testing.T) {
+	deviceplugintest.RunConformance(t, func(env deviceplugintest.Env) (deviceplugintest.Subject, error) {
+		return deviceplugintest.ServerSubject(env.Dir, "example.com/dev", "dev.sock", newMinimalServer(env.Update)), nil
+	})
+}
@@ -0,0 +1,192 @@
+package deviceplugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// DryRunConfig runs a plugin without kubelet, for bringing up a new device
+// type. The plugin is never registered, while devices are fed and checked as
+// usual. Every change of devices kubelet would get is printed as a diff, and
+// Allocate and PreStartContainer are called by commands from Input, or by
+// the admin API.
+type DryRunConfig struct {
+	// Input, if set, is read for commands, one per line:
+	//
+	//	allocate <id>...
+	//	prestart <id>...
+	//
+	// Don't share it among plugins, such as partitions.
+	Input io.Reader
+	// Output receives diffs of devices and results of commands, os.Stdout
+	// by default.
+	Output io.Writer
+}
+
+func (c *DryRunConfig) output() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
+	}
+	return c.Output
+}
+
+// syncWriter serializes writes of diffs and command results, each written
+// at once by fmt.
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (w *syncWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(b)
+}
+
+// runDry runs plugin of config in dry run, until sigCh receives false.
+func runDry(config Config, sigCh <-chan bool) error {
+	state := newResourceState(config)
+	plugin := newDevicePlugin(config, state)
+	state.dryRun = plugin
+	registerState(state)
+	defer unregisterState(state)
+	stop := make(chan struct{})
+	defer close(stop)
+	state.run(config, stop)
+
+	log.Printf("Dry run of %s, not registering to kubelet", config.ResourceName)
+	out := &syncWriter{w: config.DryRun.output()}
+	go plugin.watch(stop, deviceDiffer(config.ResourceName, out))
+	if config.DryRun.Input != nil {
+		go plugin.readCommands(config.DryRun.Input, out)
+	}
+
+	// A nil sigCh blocks forever, like Run.
+	for sig := range sigCh {
+		if !sig {
+			break
+		}
+		// There is no kubelet connection to restart.
+		log.Printf("Restart ignored in dry run")
+	}
+	log.Printf("Exit by signal")
+	return nil
+}
+
+// deviceDiffer returns a send func printing devices added, removed and
+// changed since the last send.
+func deviceDiffer(resourceName string, out io.Writer) func([]*pluginapi.Device) error {
+	var last map[string]*pluginapi.Device
+	return func(devs []*pluginapi.Device) error {
+		var lines []string
+		current := make(map[string]*pluginapi.Device, len(devs))
+		healthy := 0
+		for _, d := range devs {
+			current[d.ID] = d
+			if d.Health == pluginapi.Healthy {
+				healthy++
+			}
+			old, ok := last[d.ID]
+			switch {
+			case !ok:
+				lines = append(lines, fmt.Sprintf("+ %s %s%s", d.ID, d.Health, numaSuffix(d)))
+			case old == d:
+				// Devices unchanged are the same pointer.
+			case old.Health != d.Health:
+				lines = append(lines, fmt.Sprintf("~ %s %s -> %s", d.ID, old.Health, d.Health))
+			case numaSuffix(old) != numaSuffix(d):
+				lines = append(lines, fmt.Sprintf("~ %s%s ->%s", d.ID, numaSuffix(old), numaSuffix(d)))
+			}
+		}
+		var removed []string
+		for id := range last {
+			if _, ok := current[id]; !ok {
+				removed = append(removed, id)
+			}
+		}
+		sort.Strings(removed)
+		for _, id := range removed {
+			lines = append(lines, "- "+id)
+		}
+		last = current
+
+		_, err := fmt.Fprintf(out, "Devices of %s: %d, %d healthy\n%s", resourceName, len(devs), healthy, joinLines(lines))
+		return err
+	}
+}
+
+func numaSuffix(d *pluginapi.Device) string {
+	if d.Topology == nil || len(d.Topology.Nodes) == 0 {
+		return ""
+	}
+	nodes := make([]string, 0, len(d.Topology.Nodes))
+	for _, n := range d.Topology.Nodes {
+		nodes = append(nodes, fmt.Sprint(n.ID))
+	}
+	return " numa=" + strings.Join(nodes, ",")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// readCommands runs commands from input until it ends, and prints their
+// results.
+func (p *generalDevicePlugin) readCommands(input io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		result, err := p.dryRunCommand(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(out, "%s failed: %v\n", fields[0], err)
+			continue
+		}
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Fprintf(out, "%s failed: %v\n", fields[0], err)
+			continue
+		}
+		fmt.Fprintf(out, "%s succeeded: %s\n", fields[0], b)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("Could not read dry run commands:", err)
+	}
+}
+
+// dryRunCommand calls Allocate or PreStartContainer with devices ids for
+// one container, like kubelet does.
+func (p *generalDevicePlugin) dryRunCommand(cmd string, ids []string) (interface{}, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("devices are required")
+	}
+	switch cmd {
+	case "allocate":
+		resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.ContainerResponses[0], nil
+	case "prestart":
+		return p.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{DevicesIDs: ids})
+	default:
+		return nil, fmt.Errorf("unknown command %q, expecting allocate or prestart", cmd)
+	}
+}
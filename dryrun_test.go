@@ -0,0 +1,127 @@
+package deviceplugin
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestDeviceDiffer(t *testing.T) {
+	var out bytes.Buffer
+	send := deviceDiffer("example.com/gpu", &out)
+	dev := func(id, health string, nodes ...int64) *pluginapi.Device {
+		d := &pluginapi.Device{ID: id, Health: health}
+		if len(nodes) > 0 {
+			d.Topology = &pluginapi.TopologyInfo{}
+			for _, n := range nodes {
+				d.Topology.Nodes = append(d.Topology.Nodes, &pluginapi.NUMANode{ID: n})
+			}
+		}
+		return d
+	}
+	gpu0, gpu1, gpu2 := dev("gpu-0", pluginapi.Healthy, 0), dev("gpu-1", pluginapi.Healthy), dev("gpu-2", pluginapi.Healthy, 0, 1)
+
+	for _, step := range []struct {
+		name string
+		devs []*pluginapi.Device
+		want string
+	}{
+		{
+			name: "first",
+			devs: []*pluginapi.Device{gpu0, gpu1, gpu2},
+			want: "Devices of example.com/gpu: 3, 3 healthy\n+ gpu-0 Healthy numa=0\n+ gpu-1 Healthy\n+ gpu-2 Healthy numa=0,1\n",
+		},
+		{
+			name: "unchanged",
+			devs: []*pluginapi.Device{gpu0, gpu1, gpu2},
+			want: "Devices of example.com/gpu: 3, 3 healthy\n",
+		},
+		{
+			// Devices converted again are not the same pointer.
+			name: "copied",
+			devs: []*pluginapi.Device{dev("gpu-0", pluginapi.Healthy, 0), gpu1, gpu2},
+			want: "Devices of example.com/gpu: 3, 3 healthy\n",
+		},
+		{
+			name: "health changed",
+			devs: []*pluginapi.Device{gpu0, dev("gpu-1", pluginapi.Unhealthy), gpu2},
+			want: "Devices of example.com/gpu: 3, 2 healthy\n~ gpu-1 Healthy -> Unhealthy\n",
+		},
+		{
+			name: "topology changed",
+			devs: []*pluginapi.Device{dev("gpu-0", pluginapi.Healthy, 1), dev("gpu-1", pluginapi.Unhealthy), gpu2},
+			want: "Devices of example.com/gpu: 3, 2 healthy\n~ gpu-0 numa=0 -> numa=1\n",
+		},
+		{
+			name: "removed",
+			devs: []*pluginapi.Device{gpu2, dev("gpu-3", pluginapi.Unhealthy)},
+			want: "Devices of example.com/gpu: 2, 1 healthy\n+ gpu-3 Unhealthy\n- gpu-0\n- gpu-1\n",
+		},
+		{
+			name: "none",
+			want: "Devices of example.com/gpu: 0, 0 healthy\n- gpu-2\n- gpu-3\n",
+		},
+	} {
+		t.Run(step.name, func(t *testing.T) {
+			out.Reset()
+			if err := send(step.devs); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != step.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), step.want)
+			}
+		})
+	}
+}
+
+func TestReadCommands(t *testing.T) {
+	var prestarted []string
+	p := newDevicePlugin(Config{
+		ResourceName: "example.com/gpu",
+		AllocateFunc: func(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+			return &pluginapi.ContainerAllocateResponse{Envs: map[string]string{"GPUS": strings.Join(ids, ",")}}, nil
+		},
+		PreStartFunc: func(ids []string) error {
+			prestarted = append(prestarted, ids...)
+			if ids[0] == "gpu-1" {
+				return fmt.Errorf("no firmware")
+			}
+			return nil
+		},
+	}, nil)
+	p.state.cache.Set([]*Device{{ID: "gpu-0", Health: pluginapi.Healthy}, {ID: "gpu-1", Health: pluginapi.Healthy}})
+
+	var out bytes.Buffer
+	p.readCommands(strings.NewReader(strings.Join([]string{
+		"allocate gpu-0  gpu-1",
+		"",
+		"   ",
+		"prestart gpu-0",
+		"prestart gpu-1",
+		"allocate",
+		"allocate gpu-9",
+		"reset gpu-0",
+	}, "\n")), &out)
+
+	want := []string{
+		"allocate succeeded: {",
+		`  "envs": {`,
+		`    "GPUS": "gpu-0,gpu-1"`,
+		"  }",
+		"}",
+		"prestart succeeded: {}",
+		"prestart failed: no firmware",
+		"allocate failed: devices are required",
+		"allocate failed: rpc error: code = InvalidArgument desc = unknown device gpu-9",
+		`reset failed: unknown command "reset", expecting allocate or prestart`,
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), strings.Join(want, "\n"))
+	}
+	if strings.Join(prestarted, ",") != "gpu-0,gpu-1" {
+		t.Errorf("got prestarted %v", prestarted)
+	}
+}
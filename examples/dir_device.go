@@ -69,7 +69,7 @@ func listDevice() []*pluginapi.Device {
 	var devs []*pluginapi.Device
 	filepath.Walk(dirRoot, func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() {
-			devs = append(devs, &pluginapi.Device{info.Name(), pluginapi.Healthy})
+			devs = append(devs, &pluginapi.Device{ID: info.Name(), Health: pluginapi.Healthy})
 		}
 		return nil
 	})
@@ -0,0 +1,375 @@
+package deviceplugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// ExecConfig drives a plugin with executables, for teams not writing Go.
+// Each command is an argv, such as []string{"/opt/dev/list", "--all"}.
+//
+// Device IDs are also in env DEVICE_IDS, separated by comma. A command
+// exiting non-zero fails the call with gRPC code Unknown and its stderr,
+// unless it prints an error like {"code": 8, "message": "..."} to stdout,
+// whose code is a gRPC code.
+type ExecConfig struct {
+	// List prints devices as a JSON array, such as
+	//	[{"id": "dev-0", "health": "Healthy", "numaNodes": [0], "attrs": {"model": "x"}}]
+	// Health defaults to Healthy. List is run every ListInterval, or kept
+	// running and printing an array per line if ListInterval is 0.
+	List         []string
+	ListInterval time.Duration
+	// Allocate reads {"devicesIDs": [...]} from stdin, and prints a
+	// ContainerAllocateResponse as JSON, such as
+	//	{"envs": {"K": "V"}, "mounts": [{"container_path": "/c", "host_path": "/h"}],
+	//	 "devices": [{"container_path": "/dev/x", "host_path": "/dev/x", "permissions": "rw"}]}
+	Allocate []string
+	// PreStart reads device IDs from stdin as Allocate does.
+	PreStart []string
+	// Timeout of each run of commands, except streaming List. It's 10s by
+	// default.
+	Timeout time.Duration
+}
+
+const defaultExecTimeout = 10 * time.Second
+
+// maxExecOutput bounds stderr kept for errors.
+const maxExecOutput = 4096
+
+type execDevice struct {
+	ID        string            `json:"id"`
+	Health    string            `json:"health"`
+	NUMANodes []int64           `json:"numaNodes,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+type execRequest struct {
+	DevicesIDs []string `json:"devicesIDs"`
+}
+
+type errorBody struct {
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message"`
+}
+
+// Apply sets Devices, and AllocateFunc and PreStartFunc if their commands
+// are set, of conf. List runs until stop is closed.
+func (e ExecConfig) Apply(conf *Config, stop <-chan struct{}) {
+	conf.Update = nil
+	conf.Devices = e.Devices(stop)
+	if len(e.Allocate) > 0 {
+		conf.AllocateFunc = e.AllocateFunc()
+	}
+	if len(e.PreStart) > 0 {
+		conf.PreStartFunc = e.PreStartFunc()
+	}
+}
+
+// Devices runs List, and sends devices it prints until stop is closed.
+func (e ExecConfig) Devices(stop <-chan struct{}) <-chan []*Device {
+	ch := make(chan []*Device)
+	if e.ListInterval > 0 {
+		go e.poll(ch, stop)
+	} else {
+		go e.stream(ch, stop)
+	}
+	return ch
+}
+
+// AllocateFunc runs Allocate for each container.
+func (e ExecConfig) AllocateFunc() AllocateFunc {
+	return func(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+		out, err := e.run(e.Allocate, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &pluginapi.ContainerAllocateResponse{}
+		if err = json.Unmarshal(out, resp); err != nil {
+			return nil, status.Errorf(codes.Internal, "%s printed invalid response: %v", e.Allocate[0], err)
+		}
+		return resp, nil
+	}
+}
+
+// PreStartFunc runs PreStart before each container starts.
+func (e ExecConfig) PreStartFunc() PreStartFunc {
+	return func(ids []string) error {
+		_, err := e.run(e.PreStart, ids)
+		return err
+	}
+}
+
+func (e ExecConfig) timeout() time.Duration {
+	if e.Timeout <= 0 {
+		return defaultExecTimeout
+	}
+	return e.Timeout
+}
+
+// run runs argv with device IDs on stdin, and returns its stdout.
+func (e ExecConfig) run(argv []string, ids []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout())
+	defer cancel()
+
+	var stdin []byte
+	if ids != nil {
+		stdin, _ = json.Marshal(execRequest{DevicesIDs: ids})
+	}
+
+	var stdout bytes.Buffer
+	stderr := &tailBuffer{max: maxExecOutput}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "DEVICE_IDS="+strings.Join(ids, ","))
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+
+	wait, err := startCommand(ctx, cmd)
+	if err == nil {
+		err = wait()
+	}
+	if stderr.Len() > 0 {
+		log.Printf("%s: %s", argv[0], stderr.String())
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, status.Errorf(codes.DeadlineExceeded, "%s did not finish in %v", argv[0], e.timeout())
+	case err == nil:
+		return stdout.Bytes(), nil
+	}
+
+	if _, ok := err.(*exec.ExitError); !ok {
+		return nil, status.Errorf(codes.Unavailable, "could not run %s: %v", argv[0], err)
+	}
+	var reported errorBody
+	if json.Unmarshal(stdout.Bytes(), &reported) == nil && reported.Code != codes.OK {
+		return nil, status.Error(reported.Code, reported.Message)
+	}
+	return nil, status.Errorf(codes.Unknown, "%s failed: %v: %s", argv[0], err, strings.TrimSpace(stderr.String()))
+}
+
+// poll runs List every ListInterval, and sends devices when its output
+// changes.
+func (e ExecConfig) poll(ch chan<- []*Device, stop <-chan struct{}) {
+	ticker := time.NewTicker(e.ListInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		out, err := e.run(e.List, nil)
+		if err != nil {
+			log.Println("Could not list devices:", err)
+		} else if !bytes.Equal(out, last) {
+			if devs, err := parseExecDevices(out); err != nil {
+				log.Printf("%s printed invalid devices: %v", e.List[0], err)
+			} else if !sendDevices(ch, devs, stop) {
+				return
+			}
+			last = out
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stream keeps List running, sends devices of each line it prints, and
+// restarts it with backoff if it exits.
+func (e ExecConfig) stream(ch chan<- []*Device, stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		if err := e.streamOnce(ch, stop); err != nil {
+			log.Printf("%s exited: %v", e.List[0], err)
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+func (e ExecConfig) streamOnce(ch chan<- []*Device, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cmd := exec.Command(e.List[0], e.List[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	wait, err := startCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	// Wait closes stderr, so it's read to the end first, or the last lines,
+	// which tell why List exits, may be lost.
+	logged := make(chan struct{})
+	go func() {
+		logLines(e.List[0], stderr)
+		close(logged)
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		devs, err := parseExecDevices(line)
+		if err != nil {
+			log.Printf("%s printed invalid devices: %v", e.List[0], err)
+			continue
+		}
+		if !sendDevices(ch, devs, stop) {
+			break
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		cancel()
+	}
+	<-logged
+	if werr := wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// startCommand starts cmd in its own process group, and kills the group when
+// ctx is done, so that children keeping its output open die with it. Call
+// the returned wait instead of cmd.Wait, which stops killing once cmd is
+// reaped, since the group ID may be reused then.
+func startCommand(ctx context.Context, cmd *exec.Cmd) (wait func() error, err error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	waited := false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if !waited {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}()
+	return func() error {
+		err := cmd.Wait()
+		lock.Lock()
+		waited = true
+		lock.Unlock()
+		close(done)
+		return err
+	}, nil
+}
+
+func parseExecDevices(data []byte) ([]*Device, error) {
+	var listed []execDevice
+	if err := json.Unmarshal(data, &listed); err != nil {
+		return nil, err
+	}
+
+	devs := make([]*Device, 0, len(listed))
+	for _, d := range listed {
+		if d.ID == "" {
+			return nil, fmt.Errorf("device ID cannot be empty")
+		}
+		switch d.Health {
+		case "":
+			d.Health = pluginapi.Healthy
+		case pluginapi.Healthy, pluginapi.Unhealthy:
+		default:
+			return nil, fmt.Errorf("device %s has invalid health %q", d.ID, d.Health)
+		}
+
+		dev := &Device{ID: d.ID, Health: d.Health, Attrs: d.Attrs}
+		if len(d.NUMANodes) > 0 {
+			dev.Topology = &pluginapi.TopologyInfo{}
+			for _, n := range d.NUMANodes {
+				dev.Topology.Nodes = append(dev.Topology.Nodes, &pluginapi.NUMANode{ID: n})
+			}
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+func sendDevices(ch chan<- []*Device, devs []*Device, stop <-chan struct{}) bool {
+	select {
+	case ch <- devs:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// logLines logs lines read from r until it ends.
+func logLines(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Printf("%s: %s", name, scanner.Text())
+	}
+	// Drain the rest after a line too long, so that writer never blocks.
+	io.Copy(ioutil.Discard, r)
+}
+
+// tailBuffer keeps the last max bytes written.
+type tailBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n, _ := b.Buffer.Write(p)
+	if over := b.Len() - b.max; over > 0 {
+		b.Next(over)
+	}
+	return n, nil
+}
@@ -0,0 +1,242 @@
+package deviceplugin
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// captureLog returns what's logged until the returned func is called.
+func captureLog() func() string {
+	var lock sync.Mutex
+	var buf bytes.Buffer
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return buf.Write(p)
+	}))
+	return func() string {
+		log.SetOutput(os.Stderr)
+		lock.Lock()
+		defer lock.Unlock()
+		return buf.String()
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func sh(script string) []string {
+	return []string{"sh", "-c", script}
+}
+
+func TestExecRun(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		ids    []string
+		want   string
+		code   codes.Code
+	}{
+		{
+			name:   "stdin and env",
+			script: `printf '%s %s' "$(cat)" "$DEVICE_IDS"`,
+			ids:    []string{"dev-0", "dev-1"},
+			want:   `{"devicesIDs":["dev-0","dev-1"]} dev-0,dev-1`,
+		},
+		{
+			name:   "no devices",
+			script: `printf '[%s]' "$(cat)"`,
+			want:   `[]`,
+		},
+		{
+			name:   "failed",
+			script: `echo "no such device" >&2; exit 3`,
+			code:   codes.Unknown,
+			want:   "no such device",
+		},
+		{
+			name:   "failed with code",
+			script: `echo '{"code": 8, "message": "all busy"}'; exit 1`,
+			code:   codes.ResourceExhausted,
+			want:   "all busy",
+		},
+		{
+			name:   "code ok is ignored",
+			script: `echo '{"code": 0}'; echo oops >&2; exit 1`,
+			code:   codes.Unknown,
+			want:   "oops",
+		},
+		{
+			// Child keeping stdout open is killed with the group.
+			name:   "timeout",
+			script: `sleep 30 & sleep 30`,
+			code:   codes.DeadlineExceeded,
+			want:   "did not finish",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := ExecConfig{Timeout: 500 * time.Millisecond}
+			started := time.Now()
+			out, err := e.run(sh(tc.script), tc.ids)
+			if time.Since(started) > 5*time.Second {
+				t.Errorf("run took %v", time.Since(started))
+			}
+			if code := grpc.Code(err); code != tc.code {
+				t.Fatalf("got code %v of error %v, want %v", code, err, tc.code)
+			}
+			got := string(out)
+			if err != nil {
+				got = err.Error()
+			}
+			if !strings.Contains(got, tc.want) {
+				t.Errorf("got %q, want %q in it", got, tc.want)
+			}
+		})
+	}
+
+	_, err := ExecConfig{}.run([]string{"/nonexistent/command"}, nil)
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Errorf("got code %v of error %v running nonexistent command, want %v", code, err, codes.Unavailable)
+	}
+}
+
+func TestExecAllocate(t *testing.T) {
+	e := ExecConfig{Allocate: sh(`echo '{"envs": {"DEV": "'$DEVICE_IDS'"}, "mounts": [{"container_path": "/c", "host_path": "/h"}]}'`)}
+	resp, err := e.AllocateFunc()([]string{"dev-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Envs["DEV"] != "dev-0" || len(resp.Mounts) != 1 || resp.Mounts[0].HostPath != "/h" {
+		t.Errorf("got response %+v", resp)
+	}
+
+	e.Allocate = sh(`echo not json`)
+	if _, err = e.AllocateFunc()([]string{"dev-0"}); grpc.Code(err) != codes.Internal {
+		t.Errorf("got error %v for invalid response, want code %v", err, codes.Internal)
+	}
+}
+
+func TestParseExecDevices(t *testing.T) {
+	for _, tc := range []struct {
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{data: `[]`, want: ""},
+		{data: `[{"id": "a"}, {"id": "b", "health": "Unhealthy", "numaNodes": [0, 1], "attrs": {"m": "x"}}]`, want: "a Healthy [] map[]; b Unhealthy [0 1] map[m:x]"},
+		{data: `[{"health": "Healthy"}]`, wantErr: true},
+		{data: `[{"id": "a", "health": "Sick"}]`, wantErr: true},
+		{data: `{"id": "a"}`, wantErr: true},
+	} {
+		devs, err := parseExecDevices([]byte(tc.data))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parse %s: got error %v, want error %v", tc.data, err, tc.wantErr)
+			continue
+		}
+		var got []string
+		for _, d := range devs {
+			var nodes []int64
+			if d.Topology != nil {
+				for _, n := range d.Topology.Nodes {
+					nodes = append(nodes, n.ID)
+				}
+			}
+			got = append(got, fmt.Sprintf("%s %s %v %v", d.ID, d.Health, nodes, d.Attrs))
+		}
+		if strings.Join(got, "; ") != tc.want {
+			t.Errorf("parse %s: got %q, want %q", tc.data, strings.Join(got, "; "), tc.want)
+		}
+	}
+}
+
+func TestExecPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "devices")
+	write := func(data string) {
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"id": "a"}]`)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	ch := ExecConfig{List: []string{"cat", file}, ListInterval: 20 * time.Millisecond}.Devices(stop)
+	receive := func() []*Device {
+		select {
+		case devs := <-ch:
+			return devs
+		case <-time.After(5 * time.Second):
+			t.Fatal("no devices sent")
+			return nil
+		}
+	}
+	if devs := receive(); len(devs) != 1 || devs[0].ID != "a" {
+		t.Fatalf("got devices %v", devs)
+	}
+
+	// Same output is not sent again, and invalid output is skipped.
+	time.Sleep(100 * time.Millisecond)
+	write(`not json`)
+	time.Sleep(100 * time.Millisecond)
+	write(`[{"id": "a"}, {"id": "b", "health": "Unhealthy"}]`)
+	if devs := receive(); len(devs) != 2 || devs[1].Health != pluginapi.Unhealthy {
+		t.Fatalf("got devices %v", devs)
+	}
+}
+
+func TestExecStream(t *testing.T) {
+	logged := captureLog()
+	e := ExecConfig{List: sh(`echo '[{"id": "a"}]'; echo ''; echo '[{"id": "a"}, {"id": "b"}]'; for i in $(seq 500); do echo "line $i" >&2; done; echo "device gone" >&2; exit 1`)}
+	ch := make(chan []*Device, 10)
+	err := e.streamOnce(ch, make(chan struct{}))
+	output := logged()
+
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("got error %v, want exit status 1", err)
+	}
+	close(ch)
+	var counts []int
+	for devs := range ch {
+		counts = append(counts, len(devs))
+	}
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Errorf("got device counts %v, want [1 2]", counts)
+	}
+	// The last stderr line is logged before streamOnce returns.
+	if !strings.Contains(output, "sh: line 500") || !strings.Contains(output, "sh: device gone") {
+		t.Errorf("stderr is not logged:\n%s", output)
+	}
+}
+
+func TestExecStreamStop(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	e := ExecConfig{List: sh(`echo '[{"id": "a"}]'; sleep 30 & sleep 30`)}
+	ch := make(chan []*Device)
+	go func() { done <- e.streamOnce(ch, stop) }()
+	<-ch
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("List is not killed when stopped")
+	}
+}
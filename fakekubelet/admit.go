@@ -0,0 +1,212 @@
+package fakekubelet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// ErrInsufficient is returned by Admit if there are not enough healthy
+// devices not allocated.
+var ErrInsufficient = errors.New("insufficient devices")
+
+// Timeout of Allocate and GetPreferredAllocation calls.
+var Timeout = 10 * time.Second
+
+// Admission is devices allocated to a container.
+type Admission struct {
+	ResourceName string
+	Pod          string
+	Container    string
+	DeviceIDs    []string
+	Response     *pluginapi.ContainerAllocateResponse
+}
+
+// Admit allocates count devices of resourceName to container of pod like
+// kubelet admitting a container, calling GetPreferredAllocation, Allocate
+// and PreStartContainer as the plugin requires. Allocations are kept across
+// kubelet restarts until the pod terminates.
+func (k *Kubelet) Admit(resourceName, pod, container string, count int) (*Admission, error) {
+	owner := pod + "/" + container
+	p, ok := k.Plugin(resourceName)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s is not registered", resourceName)
+	}
+
+	devs, _ := p.Devices()
+	available := k.available(resourceName, devs)
+	if len(available) < count {
+		return nil, ErrInsufficient
+	}
+
+	ids := available[:count]
+	if p.Options.GetPreferredAllocationAvailable {
+		preferred, err := preferredAllocation(p, available, count)
+		if err != nil {
+			return nil, err
+		}
+		ids = preferred
+	}
+
+	if err := k.reserve(resourceName, owner, ids); err != nil {
+		return nil, err
+	}
+	resp, err := allocate(p, ids)
+	if err != nil {
+		k.release(resourceName, ownedBy(pod, container))
+		return nil, err
+	}
+	k.logf("Allocated %v of %s to %s", ids, resourceName, owner)
+
+	if p.Options.PreStartRequired {
+		ctx, cancel := context.WithTimeout(context.Background(), pluginapi.KubeletPreStartContainerRPCTimeoutInSecs*time.Second)
+		defer cancel()
+		if _, err = p.Client.PreStartContainer(ctx, &pluginapi.PreStartContainerRequest{DevicesIDs: ids}); err != nil {
+			k.release(resourceName, ownedBy(pod, container))
+			return nil, fmt.Errorf("PreStartContainer of %v failed: %v", ids, err)
+		}
+		k.logf("PreStartContainer of %v succeeded", ids)
+	}
+
+	return &Admission{ResourceName: resourceName, Pod: pod, Container: container, DeviceIDs: ids, Response: resp}, nil
+}
+
+// Terminate releases devices allocated to containers of pod.
+func (k *Kubelet) Terminate(pod string) {
+	k.lock.Lock()
+	resources := make([]string, 0, len(k.allocated))
+	for r := range k.allocated {
+		resources = append(resources, r)
+	}
+	k.lock.Unlock()
+
+	for _, r := range resources {
+		if ids := k.release(r, ownedBy(pod, "")); len(ids) > 0 {
+			k.logf("Released %v of %s from %s", ids, r, pod)
+		}
+	}
+}
+
+// Allocated returns devices of resourceName allocated, keyed by device ID,
+// with values of "<pod>/<container>".
+func (k *Kubelet) Allocated(resourceName string) map[string]string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	allocated := make(map[string]string, len(k.allocated[resourceName]))
+	for id, owner := range k.allocated[resourceName] {
+		allocated[id] = owner
+	}
+	return allocated
+}
+
+// available returns healthy devices not allocated, in order of ID.
+func (k *Kubelet) available(resourceName string, devs []*pluginapi.Device) []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	var ids []string
+	for _, id := range healthy(devs) {
+		if _, ok := k.allocated[resourceName][id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *Kubelet) reserve(resourceName, owner string, ids []string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	allocated := k.allocated[resourceName]
+	if allocated == nil {
+		allocated = map[string]string{}
+		k.allocated[resourceName] = allocated
+	}
+
+	for _, id := range ids {
+		if o, ok := allocated[id]; ok {
+			return fmt.Errorf("device %s is allocated to %s", id, o)
+		}
+	}
+	for _, id := range ids {
+		allocated[id] = owner
+	}
+	return nil
+}
+
+// ownedBy matches owner of container in pod, or any container of pod if
+// container is empty.
+func ownedBy(pod, container string) func(string) bool {
+	return func(owner string) bool {
+		if container == "" {
+			return strings.HasPrefix(owner, pod+"/")
+		}
+		return owner == pod+"/"+container
+	}
+}
+
+func (k *Kubelet) release(resourceName string, owned func(string) bool) []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	var ids []string
+	for id, o := range k.allocated[resourceName] {
+		if owned(o) {
+			ids = append(ids, id)
+			delete(k.allocated[resourceName], id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func preferredAllocation(p *Plugin, available []string, count int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	resp, err := p.Client.GetPreferredAllocation(ctx, &pluginapi.PreferredAllocationRequest{
+		ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{{
+			AvailableDeviceIDs: available,
+			AllocationSize:     int32(count),
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetPreferredAllocation failed: %v", err)
+	}
+	if len(resp.ContainerResponses) != 1 {
+		return nil, fmt.Errorf("GetPreferredAllocation returned %d responses for 1 container", len(resp.ContainerResponses))
+	}
+
+	ids := resp.ContainerResponses[0].DeviceIDs
+	if len(ids) != count {
+		return nil, fmt.Errorf("GetPreferredAllocation returned %d devices, %d expected", len(ids), count)
+	}
+	valid := make(map[string]bool, len(available))
+	for _, id := range available {
+		valid[id] = true
+	}
+	for _, id := range ids {
+		if !valid[id] {
+			return nil, fmt.Errorf("GetPreferredAllocation returned device %s not available", id)
+		}
+		delete(valid, id)
+	}
+	return ids, nil
+}
+
+func allocate(p *Plugin, ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	resp, err := p.Client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Allocate of %v failed: %v", ids, err)
+	}
+	if len(resp.ContainerResponses) != 1 {
+		return nil, fmt.Errorf("Allocate returned %d responses for 1 container", len(resp.ContainerResponses))
+	}
+	return resp.ContainerResponses[0], nil
+}
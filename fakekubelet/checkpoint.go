@@ -0,0 +1,84 @@
+package fakekubelet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CheckpointFile returns path of checkpoint written by WriteCheckpoint.
+func (k *Kubelet) CheckpointFile() string {
+	return filepath.Join(k.dir, "kubelet_internal_checkpoint")
+}
+
+type checkpointEntry struct {
+	PodUID        string
+	ContainerName string
+	ResourceName  string
+	// DeviceIDs are keyed by NUMA node, -1 for devices without topology.
+	DeviceIDs map[string][]string
+}
+
+// WriteCheckpoint writes devices allocated by Admit to CheckpointFile, in
+// format of kubelet device manager checkpoint. UID of a pod is "uid-<pod>".
+func (k *Kubelet) WriteCheckpoint() error {
+	k.lock.Lock()
+	byOwner := map[string][]string{}
+	for resource, allocated := range k.allocated {
+		for id, owner := range allocated {
+			key := owner + "/" + resource
+			byOwner[key] = append(byOwner[key], id)
+		}
+	}
+	plugins := make([]*Plugin, 0, len(k.plugins))
+	for _, p := range k.plugins {
+		plugins = append(plugins, p)
+	}
+	k.lock.Unlock()
+
+	registered := map[string][]string{}
+	for _, p := range plugins {
+		devs, _ := p.Devices()
+		ids := []string{}
+		for _, d := range devs {
+			ids = append(ids, d.ID)
+		}
+		registered[p.ResourceName] = ids
+	}
+
+	keys := make([]string, 0, len(byOwner))
+	for key := range byOwner {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := []checkpointEntry{}
+	for _, key := range keys {
+		// Resource name contains "/".
+		parts := strings.SplitN(key, "/", 3)
+		ids := byOwner[key]
+		sort.Strings(ids)
+		entries = append(entries, checkpointEntry{
+			PodUID:        "uid-" + parts[0],
+			ContainerName: parts[1],
+			ResourceName:  parts[2],
+			DeviceIDs:     map[string][]string{"-1": ids},
+		})
+	}
+
+	var cp struct {
+		Data struct {
+			PodDeviceEntries  []checkpointEntry
+			RegisteredDevices map[string][]string
+		}
+		Checksum uint64
+	}
+	cp.Data.PodDeviceEntries = entries
+	cp.Data.RegisteredDevices = registered
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(k.CheckpointFile(), data, 0644)
+}
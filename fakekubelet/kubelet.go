@@ -0,0 +1,203 @@
+// Package fakekubelet simulates the device manager of kubelet, for
+// developing and testing device plugins without a cluster.
+package fakekubelet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1"
+)
+
+// Kubelet serves registration on kubelet.sock under a directory, and
+// connects to plugins registered to it. It serves PodResources API on
+// pod-resources.sock in the same directory too.
+type Kubelet struct {
+	dir string
+	// Logf logs what happens to kubelet and plugins, if set.
+	Logf func(format string, args ...interface{})
+
+	lock    sync.Mutex
+	server  *grpc.Server
+	plugins map[string]*Plugin
+	// allocated maps resource name to device ID to owner.
+	allocated map[string]map[string]string
+	// registered is closed and replaced when a plugin registers.
+	registered chan struct{}
+	err        error
+}
+
+func New(dir string) *Kubelet {
+	return &Kubelet{
+		dir:        dir,
+		plugins:    map[string]*Plugin{},
+		allocated:  map[string]map[string]string{},
+		registered: make(chan struct{}),
+	}
+}
+
+// Socket returns path of kubelet socket.
+func (k *Kubelet) Socket() string {
+	return filepath.Join(k.dir, path.Base(pluginapi.KubeletSocket))
+}
+
+func (k *Kubelet) Start() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.server != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(k.dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(k.Socket()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", k.Socket())
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(k.PodResourcesSocket()); err != nil && !os.IsNotExist(err) {
+		l.Close()
+		return err
+	}
+	podResourcesListener, err := net.Listen("unix", k.PodResourcesSocket())
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	k.server = grpc.NewServer()
+	pluginapi.RegisterRegistrationServer(k.server, &registration{k})
+	podresourcesapi.RegisterPodResourcesListerServer(k.server, &podResources{k})
+	go k.server.Serve(l)
+	go k.server.Serve(podResourcesListener)
+	k.logf("Kubelet started on %s", k.Socket())
+	return nil
+}
+
+// Stop stops serving, removes kubelet socket, and disconnects all plugins.
+func (k *Kubelet) Stop() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.server == nil {
+		return
+	}
+
+	k.server.Stop()
+	k.server = nil
+	os.Remove(k.Socket())
+	os.Remove(k.PodResourcesSocket())
+	for _, p := range k.plugins {
+		p.close()
+	}
+	k.plugins = map[string]*Plugin{}
+	k.logf("Kubelet stopped")
+}
+
+// Restart simulates a kubelet restart, on which plugins shall register
+// again.
+func (k *Kubelet) Restart() error {
+	k.Stop()
+	return k.Start()
+}
+
+// Plugin returns plugin registered with resourceName.
+func (k *Kubelet) Plugin(resourceName string) (*Plugin, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	p, ok := k.plugins[resourceName]
+	return p, ok
+}
+
+// WaitForPlugin waits until a plugin registers with resourceName.
+func (k *Kubelet) WaitForPlugin(resourceName string, timeout time.Duration) (*Plugin, error) {
+	deadline := time.After(timeout)
+	for {
+		k.lock.Lock()
+		p, ok := k.plugins[resourceName]
+		registered := k.registered
+		k.lock.Unlock()
+		if ok {
+			return p, nil
+		}
+
+		select {
+		case <-registered:
+		case <-deadline:
+			return nil, fmt.Errorf("plugin %s did not register in %v", resourceName, timeout)
+		}
+	}
+}
+
+func (k *Kubelet) register(r *pluginapi.RegisterRequest) error {
+	if r.Version != pluginapi.Version {
+		return fmt.Errorf("unsupported version %s", r.Version)
+	}
+	if r.ResourceName == "" {
+		return fmt.Errorf("resource name cannot be empty")
+	}
+	if r.Endpoint != path.Base(r.Endpoint) {
+		return fmt.Errorf("endpoint %s shall be a file name", r.Endpoint)
+	}
+
+	p, err := connect(k, filepath.Join(k.dir, r.Endpoint), r)
+	if err != nil {
+		return fmt.Errorf("could not connect to plugin %s: %v", r.ResourceName, err)
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if old, ok := k.plugins[r.ResourceName]; ok {
+		old.close()
+	}
+	k.plugins[r.ResourceName] = p
+	close(k.registered)
+	k.registered = make(chan struct{})
+	k.logf("Plugin %s registered on %s with %v", r.ResourceName, r.Endpoint, r.Options)
+	return nil
+}
+
+// Err returns the first misbehaviour of plugins, including plugins
+// disconnected since.
+func (k *Kubelet) Err() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.err
+}
+
+func (k *Kubelet) fail(err error) {
+	k.lock.Lock()
+	if k.err == nil {
+		k.err = err
+	}
+	k.lock.Unlock()
+	k.logf("Misbehaviour: %v", err)
+}
+
+func (k *Kubelet) logf(format string, args ...interface{}) {
+	if k.Logf != nil {
+		k.Logf(format, args...)
+	}
+}
+
+type registration struct {
+	k *Kubelet
+}
+
+func (r *registration) Register(_ context.Context, req *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	if err := r.k.register(req); err != nil {
+		r.k.logf("Rejected registration: %v", err)
+		return nil, err
+	}
+	return &pluginapi.Empty{}, nil
+}
@@ -0,0 +1,118 @@
+package fakekubelet_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"deviceplugin"
+	"deviceplugin/fakekubelet"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// TestOwners checks that owners of devices admitted by the fake kubelet are
+// found from its checkpoint and PodResources API, and attributed to
+// allocations by a plugin.
+func TestOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakekubelet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k := fakekubelet.New(dir)
+	if err = k.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer k.Stop()
+
+	devices := make(chan []*deviceplugin.Device, 1)
+	devices <- []*deviceplugin.Device{
+		{ID: "gpu-0", Health: pluginapi.Healthy},
+		{ID: "gpu-1", Health: pluginapi.Healthy},
+		{ID: "gpu-2", Health: pluginapi.Healthy},
+	}
+	sigCh := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- deviceplugin.Run(deviceplugin.Config{
+			ResourceName:  "example.com/gpu",
+			SocketName:    "gpu.sock",
+			PluginDir:     dir,
+			Devices:       devices,
+			OwnerSource:   deviceplugin.PodResourcesOwners(k.PodResourcesSocket()),
+			OwnerInterval: 20 * time.Millisecond,
+		}, sigCh)
+	}()
+	defer func() {
+		sigCh <- false
+		if err := <-errCh; err != nil {
+			t.Error(err)
+		}
+	}()
+	p, err := k.WaitForPlugin("example.com/gpu", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.WaitForDevices(func(devs []*pluginapi.Device) bool { return len(devs) == 3 }, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = k.Admit("example.com/gpu", "pod-a", "main", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = k.Admit("example.com/gpu", "pod-b", "side", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = k.WriteCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := deviceplugin.CheckpointOwners(k.CheckpointFile()).Owners("example.com/gpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]deviceplugin.Owner{
+		"gpu-0": {PodUID: "uid-pod-a", Container: "main"},
+		"gpu-1": {PodUID: "uid-pod-a", Container: "main"},
+		"gpu-2": {PodUID: "uid-pod-b", Container: "side"},
+	}
+	if !reflect.DeepEqual(owners, want) {
+		t.Errorf("got owners %v from checkpoint, want %v", owners, want)
+	}
+
+	owners, err = deviceplugin.PodResourcesOwners(k.PodResourcesSocket()).Owners("example.com/gpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]deviceplugin.Owner{
+		"gpu-0": {Namespace: fakekubelet.Namespace, Pod: "pod-a", Container: "main"},
+		"gpu-1": {Namespace: fakekubelet.Namespace, Pod: "pod-a", Container: "main"},
+		"gpu-2": {Namespace: fakekubelet.Namespace, Pod: "pod-b", Container: "side"},
+	}
+	if !reflect.DeepEqual(owners, want) {
+		t.Errorf("got owners %v from PodResources, want %v", owners, want)
+	}
+	if owners, err = deviceplugin.PodResourcesOwners(k.PodResourcesSocket()).Owners("example.com/nic"); err != nil || len(owners) != 0 {
+		t.Errorf("got owners %v, error %v of another resource", owners, err)
+	}
+
+	// The plugin finds owners, until the pod terminates.
+	waitForOwner := func(id string, want deviceplugin.Owner, found bool) {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			o, ok := deviceplugin.OwnerOf("example.com/gpu", id)
+			if ok == found && o == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got owner %v %v of %s, want %v %v", o, ok, id, want, found)
+			}
+		}
+	}
+	waitForOwner("gpu-2", want["gpu-2"], true)
+	k.Terminate("pod-b")
+	waitForOwner("gpu-2", deviceplugin.Owner{}, false)
+	waitForOwner("gpu-0", want["gpu-0"], true)
+}
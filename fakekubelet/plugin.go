@@ -0,0 +1,174 @@
+package fakekubelet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"deviceplugin"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Plugin is a plugin registered to kubelet, whose devices are kept updated
+// by ListAndWatch.
+type Plugin struct {
+	ResourceName string
+	// Socket is path of the endpoint plugin registered.
+	Socket  string
+	Options *pluginapi.DevicePluginOptions
+	Client  pluginapi.DevicePluginClient
+
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc
+	k      *Kubelet
+
+	lock    sync.Mutex
+	closed  bool
+	devices []*pluginapi.Device
+	updates int
+	err     error
+	// changed is closed and replaced when devices are updated.
+	changed chan struct{}
+}
+
+func connect(k *Kubelet, socket string, r *pluginapi.RegisterRequest) (*Plugin, error) {
+	conn, err := deviceplugin.Dial(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := pluginapi.NewDevicePluginClient(conn)
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, err
+	}
+
+	options := r.Options
+	if options == nil {
+		options = &pluginapi.DevicePluginOptions{}
+	}
+	p := &Plugin{
+		ResourceName: r.ResourceName,
+		Socket:       socket,
+		Options:      options,
+		Client:       client,
+		conn:         conn,
+		cancel:       cancel,
+		k:            k,
+		changed:      make(chan struct{}),
+	}
+	go p.watch(stream)
+	return p, nil
+}
+
+func (p *Plugin) watch(stream pluginapi.DevicePlugin_ListAndWatchClient) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			p.lock.Lock()
+			closed := p.closed
+			p.lock.Unlock()
+			if !closed {
+				p.fail(fmt.Errorf("ListAndWatch of %s ended: %v", p.ResourceName, err))
+			}
+			return
+		}
+
+		if err = validateDevices(resp.Devices); err != nil {
+			p.fail(fmt.Errorf("%s sent invalid devices: %v", p.ResourceName, err))
+		}
+
+		p.lock.Lock()
+		p.devices = resp.Devices
+		p.updates++
+		close(p.changed)
+		p.changed = make(chan struct{})
+		p.lock.Unlock()
+		p.k.logf("%s reported %d devices, %d healthy", p.ResourceName, len(resp.Devices), len(healthy(resp.Devices)))
+	}
+}
+
+// fail records misbehaviour of plugin.
+func (p *Plugin) fail(err error) {
+	p.lock.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.lock.Unlock()
+	p.k.fail(err)
+}
+
+func (p *Plugin) close() {
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.cancel()
+	p.conn.Close()
+}
+
+// Devices returns devices last reported, and how many times reported.
+func (p *Plugin) Devices() ([]*pluginapi.Device, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.devices, p.updates
+}
+
+// Err returns the first misbehaviour found on ListAndWatch.
+func (p *Plugin) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+// WaitForDevices waits until devices reported satisfy cond. It waits for
+// the first report even if cond accepts no devices.
+func (p *Plugin) WaitForDevices(cond func([]*pluginapi.Device) bool, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		p.lock.Lock()
+		devs, updates, changed := p.devices, p.updates, p.changed
+		p.lock.Unlock()
+		if updates > 0 && cond(devs) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("devices of %s are not as expected in %v", p.ResourceName, timeout)
+		}
+	}
+}
+
+func validateDevices(devs []*pluginapi.Device) error {
+	seen := make(map[string]bool, len(devs))
+	for _, d := range devs {
+		if d.ID == "" {
+			return fmt.Errorf("device ID cannot be empty")
+		}
+		if seen[d.ID] {
+			return fmt.Errorf("duplicated device %s", d.ID)
+		}
+		seen[d.ID] = true
+		if d.Health != pluginapi.Healthy && d.Health != pluginapi.Unhealthy {
+			return fmt.Errorf("device %s has invalid health %q", d.ID, d.Health)
+		}
+	}
+	return nil
+}
+
+func healthy(devs []*pluginapi.Device) []string {
+	var ids []string
+	for _, d := range devs {
+		if d.Health == pluginapi.Healthy {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids
+}
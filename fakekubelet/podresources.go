@@ -0,0 +1,87 @@
+package fakekubelet
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1"
+)
+
+// Namespace of pods listed by PodResources API.
+const Namespace = "default"
+
+// PodResourcesSocket returns path of PodResources API socket, served along
+// with kubelet socket.
+func (k *Kubelet) PodResourcesSocket() string {
+	return filepath.Join(k.dir, "pod-resources.sock")
+}
+
+type podResources struct {
+	k *Kubelet
+}
+
+// List lists devices allocated by Admit, and not released by Terminate.
+func (s *podResources) List(context.Context, *podresourcesapi.ListPodResourcesRequest) (*podresourcesapi.ListPodResourcesResponse, error) {
+	s.k.lock.Lock()
+	// keys are "<pod>/<container>/<resource>/<id>".
+	var keys []string
+	for resource, allocated := range s.k.allocated {
+		for id, owner := range allocated {
+			keys = append(keys, owner+"/"+resource+"/"+id)
+		}
+	}
+	s.k.lock.Unlock()
+	sort.Strings(keys)
+
+	resp := &podresourcesapi.ListPodResourcesResponse{}
+	var pod *podresourcesapi.PodResources
+	var container *podresourcesapi.ContainerResources
+	var devices *podresourcesapi.ContainerDevices
+	for _, key := range keys {
+		// Resource name contains "/".
+		parts := strings.SplitN(key, "/", 3)
+		i := strings.LastIndex(parts[2], "/")
+		resource, id := parts[2][:i], parts[2][i+1:]
+
+		if pod == nil || pod.Name != parts[0] {
+			pod = &podresourcesapi.PodResources{Name: parts[0], Namespace: Namespace}
+			resp.PodResources = append(resp.PodResources, pod)
+			container = nil
+		}
+		if container == nil || container.Name != parts[1] {
+			container = &podresourcesapi.ContainerResources{Name: parts[1]}
+			pod.Containers = append(pod.Containers, container)
+			devices = nil
+		}
+		if devices == nil || devices.ResourceName != resource {
+			devices = &podresourcesapi.ContainerDevices{ResourceName: resource}
+			container.Devices = append(container.Devices, devices)
+		}
+		devices.DeviceIds = append(devices.DeviceIds, id)
+	}
+	return resp, nil
+}
+
+// GetAllocatableResources lists devices reported by registered plugins.
+func (s *podResources) GetAllocatableResources(context.Context, *podresourcesapi.AllocatableResourcesRequest) (*podresourcesapi.AllocatableResourcesResponse, error) {
+	s.k.lock.Lock()
+	plugins := make([]*Plugin, 0, len(s.k.plugins))
+	for _, p := range s.k.plugins {
+		plugins = append(plugins, p)
+	}
+	s.k.lock.Unlock()
+
+	resp := &podresourcesapi.AllocatableResourcesResponse{}
+	for _, p := range plugins {
+		devs, _ := p.Devices()
+		ids := make([]string, 0, len(devs))
+		for _, d := range devs {
+			ids = append(ids, d.ID)
+		}
+		resp.Devices = append(resp.Devices, &podresourcesapi.ContainerDevices{ResourceName: p.ResourceName, DeviceIds: ids})
+	}
+	sort.Slice(resp.Devices, func(i, j int) bool { return resp.Devices[i].ResourceName < resp.Devices[j].ResourceName })
+	return resp, nil
+}
@@ -0,0 +1,322 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// HostPathPoolConfig configures a pool of host directories handed out as
+// devices.
+type HostPathPoolConfig struct {
+	// Root is where directories are created, named Prefix followed by
+	// index, such as dir-0.
+	Root   string
+	Prefix string
+	Count  int
+	// ContainerPath is where an allocated directory is mounted. If a
+	// container gets several, each is mounted at ContainerPath/<id>.
+	ContainerPath string
+	// Directories are owned by UID and GID, with Mode, 0777 by default.
+	UID, GID int
+	Mode     os.FileMode
+	// RetryInterval is how often failed wipes are retried, 1m by default.
+	RetryInterval time.Duration
+	// Owners, if set, lists directories of ResourceName in use at start,
+	// so that the others holding data are wiped at once.
+	Owners       OwnerSource
+	ResourceName string
+}
+
+// HostPathPool advertises directories under a root as devices, and wipes
+// each released directory before advertising it as Healthy again.
+type HostPathPool struct {
+	conf HostPathPoolConfig
+	// wipeLock serializes wipes.
+	wipeLock sync.Mutex
+
+	lock sync.Mutex
+	// dirty are directories released but not wiped successfully yet.
+	dirty   map[string]bool
+	devices []*Device
+	changed chan struct{}
+}
+
+// NewHostPathPool creates directories of conf which do not exist. Existing
+// ones holding data may be used by containers, so they are Unhealthy and
+// kept as they are until released or wiped, unless Owners tells they are
+// not in use, in which case they are wiped at once.
+func NewHostPathPool(conf HostPathPoolConfig) (*HostPathPool, error) {
+	if conf.Root == "" || conf.Count <= 0 || conf.ContainerPath == "" {
+		return nil, fmt.Errorf("root, count and container path cannot be empty")
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "dir-"
+	}
+	if conf.Mode == 0 {
+		conf.Mode = 0777
+	}
+	if conf.RetryInterval <= 0 {
+		conf.RetryInterval = time.Minute
+	}
+
+	p := &HostPathPool{conf: conf, dirty: map[string]bool{}, changed: make(chan struct{}, 1)}
+	for i := 0; i < conf.Count; i++ {
+		id := fmt.Sprintf("%s%d", conf.Prefix, i)
+		path := p.path(id)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			if err = p.reset(path); err != nil {
+				return nil, err
+			}
+		}
+
+		health := pluginapi.Healthy
+		if err := p.checkDir(path, true); err != nil {
+			log.Printf("Directory %s is unhealthy until wiped: %v", path, err)
+			health = pluginapi.Unhealthy
+		}
+		p.devices = append(p.devices, &Device{ID: id, Health: health, Attrs: map[string]string{"path": path}})
+	}
+	if conf.Owners != nil {
+		p.wipeUnused()
+	}
+	return p, nil
+}
+
+// wipeUnused wipes Unhealthy directories not assigned to any container.
+// Those failing to be wiped are retried.
+func (p *HostPathPool) wipeUnused() {
+	owners, err := p.conf.Owners.Owners(p.conf.ResourceName)
+	if err != nil {
+		log.Printf("Could not list directories in use, keeping them until released: %v", err)
+		return
+	}
+	for _, d := range p.list() {
+		if _, ok := owners[d.ID]; ok || d.Health == pluginapi.Healthy {
+			continue
+		}
+		p.lock.Lock()
+		p.dirty[d.ID] = true
+		p.lock.Unlock()
+		p.wipe(d.ID)
+	}
+}
+
+func (p *HostPathPool) path(id string) string {
+	return filepath.Join(p.conf.Root, id)
+}
+
+// Devices sends directories whenever their health changes, and retries
+// failed wipes until stop is closed.
+func (p *HostPathPool) Devices(stop <-chan struct{}) <-chan []*Device {
+	ch := make(chan []*Device)
+	go func() {
+		ticker := time.NewTicker(p.conf.RetryInterval)
+		defer ticker.Stop()
+		for {
+			if !sendDevices(ch, p.list(), stop) {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-p.changed:
+			case <-ticker.C:
+				p.retry()
+			}
+		}
+	}()
+	return ch
+}
+
+// Allocate mounts directories of ids at ContainerPath.
+func (p *HostPathPool) Allocate(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	resp := &pluginapi.ContainerAllocateResponse{}
+	for _, id := range ids {
+		containerPath := p.conf.ContainerPath
+		if len(ids) > 1 {
+			containerPath = filepath.Join(containerPath, id)
+		}
+		resp.Mounts = append(resp.Mounts, &pluginapi.Mount{ContainerPath: containerPath, HostPath: p.path(id)})
+	}
+	return resp, nil
+}
+
+// Release marks directory of id Unhealthy, wipes it, and marks it Healthy
+// again once it's empty and owned as configured. Call it when the container
+// using the directory is gone.
+func (p *HostPathPool) Release(id string) {
+	if !p.setHealth(id, pluginapi.Unhealthy) {
+		log.Printf("Released unknown directory %s", id)
+		return
+	}
+
+	p.lock.Lock()
+	p.dirty[id] = true
+	p.lock.Unlock()
+	go p.wipe(id)
+}
+
+func (p *HostPathPool) retry() {
+	p.lock.Lock()
+	var ids []string
+	for id := range p.dirty {
+		ids = append(ids, id)
+	}
+	p.lock.Unlock()
+
+	sort.Strings(ids)
+	for _, id := range ids {
+		p.wipe(id)
+	}
+}
+
+func (p *HostPathPool) wipe(id string) {
+	p.lock.Lock()
+	dirty := p.dirty[id]
+	p.lock.Unlock()
+	if !dirty {
+		return
+	}
+	if err := p.Wipe(id); err != nil {
+		log.Println(err)
+	}
+}
+
+// Wipe wipes directory of id at once, and marks it Healthy if it's empty
+// and owned as configured afterwards. Unlike Release, it returns whether
+// wipe succeeded, so it can be Config.ReleaseFunc, which keeps the
+// directory Unhealthy to kubelet and retries until it succeeds.
+func (p *HostPathPool) Wipe(id string) error {
+	if _, ok := p.get(id); !ok {
+		return fmt.Errorf("unknown directory %s", id)
+	}
+
+	p.wipeLock.Lock()
+	defer p.wipeLock.Unlock()
+	path := p.path(id)
+	if err := p.reset(path); err != nil {
+		return fmt.Errorf("could not wipe directory %s: %v", path, err)
+	}
+	if err := p.checkDir(path, true); err != nil {
+		return fmt.Errorf("directory %s is unhealthy after wipe: %v", path, err)
+	}
+
+	p.lock.Lock()
+	delete(p.dirty, id)
+	p.lock.Unlock()
+	p.setHealth(id, pluginapi.Healthy)
+	log.Printf("Directory %s is wiped", path)
+	return nil
+}
+
+// reset empties path, creating it if not exists, and restores its
+// ownership and mode, which containers may have changed.
+func (p *HostPathPool) reset(path string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// RemoveAll removes symlinks rather than what they point to.
+		if err = os.RemoveAll(filepath.Join(path, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Lchown(path, p.conf.UID, p.conf.GID); err != nil {
+		return err
+	}
+	return os.Chmod(path, p.conf.Mode)
+}
+
+// checkDir checks path is a directory owned as configured, and holds
+// nothing if empty is set.
+func (p *HostPathPool) checkDir(path string, empty bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	if info.Mode().Perm() != p.conf.Mode.Perm() {
+		return fmt.Errorf("mode is %v, not %v", info.Mode().Perm(), p.conf.Mode.Perm())
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && (int(st.Uid) != p.conf.UID || int(st.Gid) != p.conf.GID) {
+		return fmt.Errorf("owned by %d:%d, not %d:%d", st.Uid, st.Gid, p.conf.UID, p.conf.GID)
+	}
+
+	if empty {
+		if size, err := dirSize(path); err != nil {
+			return err
+		} else if size > 0 {
+			return fmt.Errorf("%d bytes left", size)
+		}
+	}
+	return nil
+}
+
+// dirSize returns bytes of files under path, without following symlinks.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != path {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func (p *HostPathPool) setHealth(id, health string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, d := range p.devices {
+		if d.ID == id {
+			if d.Health != health {
+				// Devices sent are not changed, so copy it.
+				updated := *d
+				updated.Health = health
+				p.devices[i] = &updated
+				select {
+				case p.changed <- struct{}{}:
+				default:
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (p *HostPathPool) get(id string) (*Device, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, d := range p.devices {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (p *HostPathPool) list() []*Device {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*Device(nil), p.devices...)
+}
@@ -0,0 +1,243 @@
+package deviceplugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// fakeOwners is an OwnerSource of fixed owners, failing if err is set.
+type fakeOwners struct {
+	owners map[string]Owner
+	err    error
+}
+
+func (f *fakeOwners) Owners(string) (map[string]Owner, error) {
+	return f.owners, f.err
+}
+
+func testHostPathPoolConfig(t *testing.T) HostPathPoolConfig {
+	root, err := ioutil.TempDir("", "hostpath-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	return HostPathPoolConfig{
+		Root:          root,
+		Count:         3,
+		ContainerPath: "/scratch",
+		UID:           os.Getuid(),
+		GID:           os.Getgid(),
+		Mode:          0750,
+	}
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func healthOf(p *HostPathPool) map[string]string {
+	health := map[string]string{}
+	for _, d := range p.list() {
+		health[d.ID] = d.Health
+	}
+	return health
+}
+
+func TestHostPathPoolStart(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		owners OwnerSource
+		// want is health of dir-0 holding data, and whether it's wiped.
+		want  string
+		wiped bool
+	}{
+		{name: "no owners", want: pluginapi.Unhealthy},
+		{name: "in use", owners: &fakeOwners{owners: map[string]Owner{"dir-0": {Container: "c"}}}, want: pluginapi.Unhealthy},
+		{name: "not in use", owners: &fakeOwners{owners: map[string]Owner{}}, want: pluginapi.Healthy, wiped: true},
+		{name: "owners failing", owners: &fakeOwners{err: os.ErrNotExist}, want: pluginapi.Unhealthy},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testHostPathPoolConfig(t)
+			conf.Owners = tc.owners
+			conf.ResourceName = "example.com/scratch"
+			writeTestFile(t, filepath.Join(conf.Root, "dir-0", "data"), "left by last pod")
+			if err := os.Chmod(filepath.Join(conf.Root, "dir-0"), conf.Mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(conf.Root, "dir-1"), 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := NewHostPathPool(conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			health := healthOf(p)
+			if health["dir-0"] != tc.want {
+				t.Errorf("dir-0 is %s, want %s", health["dir-0"], tc.want)
+			}
+			_, err = os.Stat(filepath.Join(conf.Root, "dir-0", "data"))
+			if wiped := os.IsNotExist(err); wiped != tc.wiped {
+				t.Errorf("dir-0 wiped is %v, want %v", wiped, tc.wiped)
+			}
+			// Empty directory of a wrong mode is Unhealthy until wiped too,
+			// while a new one is created as configured.
+			if health["dir-1"] != pluginapi.Unhealthy && tc.owners == nil {
+				t.Errorf("dir-1 of mode 0700 is %s", health["dir-1"])
+			}
+			if health["dir-2"] != pluginapi.Healthy {
+				t.Errorf("created dir-2 is %s", health["dir-2"])
+			}
+
+			if err = p.Wipe("dir-0"); err != nil {
+				t.Fatal(err)
+			}
+			if health := healthOf(p); health["dir-0"] != pluginapi.Healthy {
+				t.Errorf("dir-0 is %s after wipe", health["dir-0"])
+			}
+		})
+	}
+}
+
+func TestHostPathPoolWipe(t *testing.T) {
+	conf := testHostPathPoolConfig(t)
+	p, err := NewHostPathPool(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(conf.Root, "outside")
+	writeTestFile(t, outside, "not in pool")
+	dir := filepath.Join(conf.Root, "dir-1")
+	writeTestFile(t, filepath.Join(dir, "a", "b", "data"), "data")
+	if err = os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.Wipe("dir-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.checkDir(dir, true); err != nil {
+		t.Errorf("dir-1 after wipe: %v", err)
+	}
+	if _, err = os.Stat(outside); err != nil {
+		t.Errorf("symlink target is removed: %v", err)
+	}
+	if err = p.Wipe("dir-9"); err == nil {
+		t.Error("unknown directory is wiped")
+	}
+}
+
+func TestHostPathPoolRelease(t *testing.T) {
+	conf := testHostPathPoolConfig(t)
+	p, err := NewHostPathPool(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	ch := p.Devices(stop)
+	<-ch
+
+	writeTestFile(t, filepath.Join(conf.Root, "dir-0", "data"), "data")
+	p.Release("dir-0")
+	// Unhealthy may be coalesced with Healthy after wipe, if wipe is fast.
+	timeout := time.After(5 * time.Second)
+	for healthy := false; !healthy; {
+		select {
+		case devs := <-ch:
+			healthy = devs[0].Health == pluginapi.Healthy
+		case <-timeout:
+			t.Fatal("dir-0 is not healthy again")
+		}
+	}
+	if size, err := dirSize(filepath.Join(conf.Root, "dir-0")); err != nil || size != 0 {
+		t.Errorf("dir-0 holds %d bytes after release, %v", size, err)
+	}
+
+	// Unknown directory is ignored.
+	p.Release("dir-9")
+}
+
+func TestCheckDir(t *testing.T) {
+	conf := testHostPathPoolConfig(t)
+	p := &HostPathPool{conf: conf}
+	ok := filepath.Join(conf.Root, "ok")
+	if err := p.reset(ok); err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(conf.Root, "full")
+	if err := p.reset(full); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(full, "data"), "data")
+	if err := os.Chmod(full, conf.Mode); err != nil {
+		t.Fatal(err)
+	}
+	mode := filepath.Join(conf.Root, "mode")
+	if err := os.Mkdir(mode, 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(conf.Root, "file")
+	writeTestFile(t, file, "")
+
+	for _, tc := range []struct {
+		path    string
+		empty   bool
+		wantErr bool
+	}{
+		{path: ok, empty: true},
+		{path: full},
+		{path: full, empty: true, wantErr: true},
+		{path: mode, wantErr: true},
+		{path: file, wantErr: true},
+		{path: filepath.Join(conf.Root, "missing"), wantErr: true},
+	} {
+		err := p.checkDir(tc.path, tc.empty)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkDir(%s, %v) = %v, want error %v", filepath.Base(tc.path), tc.empty, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	conf := testHostPathPoolConfig(t)
+	writeTestFile(t, filepath.Join(conf.Root, "a"), "12345")
+	writeTestFile(t, filepath.Join(conf.Root, "d", "b"), "123")
+	big := filepath.Join(conf.Root, "..", filepath.Base(conf.Root)+"-big")
+	writeTestFile(t, big, string(make([]byte, 1000)))
+	defer os.Remove(big)
+	if err := os.Symlink(big, filepath.Join(conf.Root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := dirSize(conf.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Symlink counts as its own size, not of what it points to, and the
+	// directory entry of d counts as well.
+	info, err := os.Lstat(filepath.Join(conf.Root, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirInfo, err := os.Lstat(filepath.Join(conf.Root, "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 8 + info.Size() + dirInfo.Size(); size != want {
+		t.Errorf("got %d bytes, want %d", size, want)
+	}
+}
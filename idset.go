@@ -0,0 +1,151 @@
+package deviceplugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idSet is a set of device IDs with time each is added, persisted in file
+// if it is set.
+type idSet struct {
+	lock sync.RWMutex
+	file string
+	ids  map[string]time.Time
+}
+
+type idSetFile struct {
+	Devices map[string]time.Time `json:"devices"`
+}
+
+// stateFile returns path of state file name of resource under dir, or ""
+// if dir is not set.
+func stateFile(dir, resourceName, name string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, strings.Replace(resourceName, "/", "_", -1)+"."+name)
+}
+
+func loadIDSet(file string) *idSet {
+	c := &idSet{file: file, ids: map[string]time.Time{}}
+	if file == "" {
+		return c
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c
+	} else if err != nil {
+		log.Printf("Could not read devices from %s: %v", file, err)
+		return c
+	}
+	var f idSetFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		log.Printf("Invalid devices in %s: %v", file, err)
+		return c
+	}
+	if f.Devices != nil {
+		c.ids = f.Devices
+	}
+	return c
+}
+
+// Set adds or removes id, and returns whether it's changed.
+func (c *idSet) Set(id string, added bool) (bool, error) {
+	changed := false
+	err := c.Update(func(ids map[string]time.Time) bool {
+		if _, ok := ids[id]; ok == added {
+			return false
+		}
+		changed = true
+		if added {
+			ids[id] = time.Now()
+		} else {
+			delete(ids, id)
+		}
+		return true
+	})
+	return changed, err
+}
+
+// Update changes a copy of IDs with update, and saves it once if update
+// returns true.
+func (c *idSet) Update(update func(map[string]time.Time) bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ids := make(map[string]time.Time, len(c.ids)+1)
+	for k, v := range c.ids {
+		ids[k] = v
+	}
+	if !update(ids) {
+		return nil
+	}
+	if err := c.save(ids); err != nil {
+		return err
+	}
+	c.ids = ids
+	return nil
+}
+
+func (c *idSet) save(ids map[string]time.Time) error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(idSetFile{Devices: ids}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.file, data, 0644)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// first, creating the directory if not exists, then renames it to path, so
+// that readers never see a partial file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns when id was added, if it is.
+func (c *idSet) Get(id string) (time.Time, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t, ok := c.ids[id]
+	return t, ok
+}
+
+// List returns IDs in order.
+func (c *idSet) List() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	ids := make([]string, 0, len(c.ids))
+	for id := range c.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
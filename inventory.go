@@ -0,0 +1,214 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// NodeLabeler patches labels of a Node, such as a few lines wrapping a
+// client-go clientset.
+type NodeLabeler interface {
+	// PatchNodeLabels sets labels of node, removing those whose value is
+	// nil.
+	PatchNodeLabels(nodeName string, labels map[string]*string) error
+}
+
+// InventoryConfig publishes device counts as node labels, for selecting
+// nodes by device model and such. For resource example.com/gpu, labels are
+//
+//	example.com/gpu.count=8
+//	example.com/gpu.healthy=7
+//	example.com/gpu.<attr>.<value>=<count of devices>
+type InventoryConfig struct {
+	// Attrs are device attributes counted, such as model and firmware.
+	Attrs []string
+	// FeatureFile, if set, is a node-feature-discovery features.d file
+	// written, such as
+	// /etc/kubernetes/node-feature-discovery/features.d/example-gpu.
+	// Partitions write it suffixed by their resource names.
+	FeatureFile string
+	// Labeler, if set, patches labels of node NodeName, NODE_NAME env by
+	// default.
+	Labeler  NodeLabeler
+	NodeName string
+}
+
+var labelInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func (c *InventoryConfig) validate() error {
+	if c.FeatureFile == "" && c.Labeler == nil {
+		return fmt.Errorf("either inventory feature file or labeler shall be set")
+	}
+	if c.Labeler != nil && nodeNameOrEnv(c.NodeName) == "" {
+		return fmt.Errorf("inventory node name cannot be empty, set it or NODE_NAME env")
+	}
+	return nil
+}
+
+// inventoryLabels returns labels of devs of resourceName. Labels whose key
+// is invalid, such as too long, are left out.
+func inventoryLabels(resourceName string, attrs []string, devs []*Device) map[string]string {
+	counts := map[string]int{
+		resourceName + ".count":   len(devs),
+		resourceName + ".healthy": 0,
+	}
+	for _, d := range devs {
+		if d.Health == pluginapi.Healthy {
+			counts[resourceName+".healthy"]++
+		}
+		for _, attr := range attrs {
+			value, ok := d.Attrs[attr]
+			if !ok {
+				continue
+			}
+			value = strings.Trim(labelInvalidChars.ReplaceAllString(value, "-"), "-_.")
+			if value == "" {
+				continue
+			}
+			counts[resourceName+"."+attr+"."+value]++
+		}
+	}
+
+	labels := make(map[string]string, len(counts))
+	for key, count := range counts {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			log.Printf("Left out label %s: %s", key, strings.Join(errs, "; "))
+			continue
+		}
+		labels[key] = strconv.Itoa(count)
+	}
+	return labels
+}
+
+// inventory keeps labels of devices published.
+type inventory struct {
+	resourceName string
+	conf         *InventoryConfig
+	nodeName     string
+
+	// written are labels last written to feature file.
+	written map[string]string
+	// patched are labels last patched to node.
+	patched map[string]string
+	// published are keys of labels patched to node, kept in StateDir so
+	// that labels no longer published are removed after restarts too.
+	published *idSet
+}
+
+func newInventory(conf Config) *inventory {
+	return &inventory{
+		resourceName: conf.ResourceName,
+		conf:         conf.Inventory,
+		nodeName:     nodeNameOrEnv(conf.Inventory.NodeName),
+		published:    loadIDSet(stateFile(conf.StateDir, conf.ResourceName, "labels.json")),
+	}
+}
+
+// run publishes labels whenever devices in cache change, and retries
+// failed writes every minute, until stop is closed.
+func (i *inventory) run(cache *deviceCache, stop <-chan struct{}) {
+	changed, cancel := cache.Watch()
+	defer cancel()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		if devs, synced := cache.List(); synced {
+			i.publish(inventoryLabels(i.resourceName, i.conf.Attrs, devs))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish writes labels if they changed since last written.
+func (i *inventory) publish(labels map[string]string) {
+	if i.conf.FeatureFile != "" && !reflect.DeepEqual(labels, i.written) {
+		if err := writeFeatureFile(i.conf.FeatureFile, labels); err != nil {
+			log.Printf("Could not write feature file %s: %v", i.conf.FeatureFile, err)
+		} else {
+			i.written = labels
+		}
+	}
+
+	if i.conf.Labeler != nil && !reflect.DeepEqual(labels, i.patched) {
+		patch := map[string]*string{}
+		for _, key := range i.published.List() {
+			if _, ok := labels[key]; !ok {
+				patch[key] = nil
+			}
+		}
+		for key, value := range labels {
+			if old, ok := i.patched[key]; !ok || old != value {
+				v := value
+				patch[key] = &v
+			}
+		}
+		if err := i.patch(patch); err != nil {
+			log.Printf("Could not patch labels of node %s: %v", i.nodeName, err)
+		} else {
+			i.patched = labels
+		}
+	}
+}
+
+// patch patches labels of node, keeping keys published before it's patched,
+// so that a key is never forgotten if patch fails.
+func (i *inventory) patch(patch map[string]*string) error {
+	err := i.published.Update(func(keys map[string]time.Time) bool {
+		changed := false
+		for key, value := range patch {
+			if _, ok := keys[key]; !ok && value != nil {
+				keys[key] = time.Now()
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+	if err = i.conf.Labeler.PatchNodeLabels(i.nodeName, patch); err != nil {
+		return err
+	}
+	return i.published.Update(func(keys map[string]time.Time) bool {
+		changed := false
+		for key, value := range patch {
+			if _, ok := keys[key]; ok && value == nil {
+				delete(keys, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// writeFeatureFile writes labels in node-feature-discovery local feature
+// format, one key=value per line. It's written atomically, so that NFD
+// never reads a partial file.
+func writeFeatureFile(path string, labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, labels[key])
+	}
+
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,157 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// fakeLabeler records patches, failing if err is set.
+type fakeLabeler struct {
+	err     error
+	patches []map[string]*string
+}
+
+func (l *fakeLabeler) PatchNodeLabels(nodeName string, labels map[string]*string) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.patches = append(l.patches, labels)
+	return nil
+}
+
+// last returns the last patch, with "-" for labels removed.
+func (l *fakeLabeler) last() map[string]string {
+	if len(l.patches) == 0 {
+		return nil
+	}
+	patch := map[string]string{}
+	for key, value := range l.patches[len(l.patches)-1] {
+		if value == nil {
+			patch[key] = "-"
+		} else {
+			patch[key] = *value
+		}
+	}
+	return patch
+}
+
+func TestInventoryLabels(t *testing.T) {
+	devs := []*Device{
+		{ID: "0", Health: pluginapi.Healthy, Attrs: map[string]string{"model": "A100 SXM4/40GB", "fw": "1.2"}},
+		{ID: "1", Health: pluginapi.Unhealthy, Attrs: map[string]string{"model": "A100 SXM4/40GB"}},
+		{ID: "2", Health: pluginapi.Healthy, Attrs: map[string]string{"model": "--", "fw": strings.Repeat("x", 64)}},
+	}
+	got := inventoryLabels("example.com/gpu", []string{"model", "fw"}, devs)
+	want := map[string]string{
+		"example.com/gpu.count":                "3",
+		"example.com/gpu.healthy":              "2",
+		"example.com/gpu.model.A100-SXM4-40GB": "2",
+		"example.com/gpu.fw.1.2":               "1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+}
+
+func TestInventoryPublish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	labeler := &fakeLabeler{}
+	conf := Config{
+		ResourceName: "example.com/gpu",
+		StateDir:     dir,
+		Inventory: &InventoryConfig{
+			FeatureFile: filepath.Join(dir, "features.d", "example-gpu"),
+			Labeler:     labeler,
+			NodeName:    "node-0",
+		},
+	}
+
+	var inv *inventory
+	for _, step := range []struct {
+		name    string
+		restart bool
+		fail    bool
+		labels  map[string]string
+		// patch is patched, with "-" for labels removed, nil if none.
+		patch map[string]string
+	}{
+		{
+			name:   "first",
+			labels: map[string]string{"a": "1", "b": "2"},
+			patch:  map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:   "unchanged",
+			labels: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:   "changed",
+			labels: map[string]string{"a": "1", "c": "3"},
+			patch:  map[string]string{"b": "-", "c": "3"},
+		},
+		{
+			name:   "failed",
+			fail:   true,
+			labels: map[string]string{"a": "1", "d": "4"},
+		},
+		{
+			// Labels published before restart, and by the failed patch
+			// if it went through, are removed.
+			name:    "restarted",
+			restart: true,
+			labels:  map[string]string{"a": "2"},
+			patch:   map[string]string{"a": "2", "c": "-", "d": "-"},
+		},
+		{
+			name:    "restarted again",
+			restart: true,
+			labels:  map[string]string{"a": "2"},
+			patch:   map[string]string{"a": "2"},
+		},
+	} {
+		t.Run(step.name, func(t *testing.T) {
+			if inv == nil || step.restart {
+				inv = newInventory(conf)
+			}
+			labeler.err = nil
+			if step.fail {
+				labeler.err = fmt.Errorf("forbidden")
+			}
+			patches := len(labeler.patches)
+			inv.publish(step.labels)
+
+			if step.patch == nil {
+				if len(labeler.patches) != patches {
+					t.Errorf("patched %v, want none", labeler.last())
+				}
+			} else if got := labeler.last(); !reflect.DeepEqual(got, step.patch) {
+				t.Errorf("patched %v, want %v", got, step.patch)
+			}
+
+			data, err := ioutil.ReadFile(conf.Inventory.FeatureFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if value, ok := step.labels[key]; ok {
+					want = append(want, key+"="+value+"\n")
+				}
+			}
+			if string(data) != strings.Join(want, "") {
+				t.Errorf("feature file is %q, want %q", data, strings.Join(want, ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1"
+)
+
+// DefaultPodResourcesSocket is where kubelet serves the PodResources API.
+const DefaultPodResourcesSocket = "/var/lib/kubelet/pod-resources/kubelet.sock"
+
+// DefaultCheckpointFile is where kubelet keeps devices assigned to
+// containers.
+var DefaultCheckpointFile = filepath.Join(pluginapi.DevicePluginPath, "kubelet_internal_checkpoint")
+
+const defaultOwnerInterval = 10 * time.Second
+
+// Owner is the container a device is assigned to. PodResources API tells
+// namespace and name of pod, while kubelet checkpoint tells pod UID.
+type Owner struct {
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	PodUID    string `json:"podUID,omitempty"`
+	Container string `json:"container"`
+}
+
+func (o Owner) String() string {
+	pod := o.Namespace + "/" + o.Pod
+	if o.Pod == "" {
+		pod = o.PodUID
+	}
+	return pod + "/" + o.Container
+}
+
+// OwnerSource lists devices of a resource assigned to containers.
+type OwnerSource interface {
+	Owners(resourceName string) (map[string]Owner, error)
+}
+
+// PodResourcesOwners lists owners by kubelet PodResources API on socket.
+func PodResourcesOwners(socket string) OwnerSource {
+	return &podResourcesOwners{socket: socket}
+}
+
+type podResourcesOwners struct {
+	socket string
+
+	lock sync.Mutex
+	conn *grpc.ClientConn
+}
+
+func (s *podResourcesOwners) Owners(resourceName string) (map[string]Owner, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		conn, err := Dial(s.socket)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := podresourcesapi.NewPodResourcesListerClient(s.conn).List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		// Dial again next time, in case kubelet restarted.
+		s.conn.Close()
+		s.conn = nil
+		return nil, err
+	}
+
+	owners := map[string]Owner{}
+	for _, pod := range resp.PodResources {
+		for _, c := range pod.Containers {
+			for _, d := range c.Devices {
+				if d.ResourceName != resourceName {
+					continue
+				}
+				for _, id := range d.DeviceIds {
+					owners[id] = Owner{Namespace: pod.Namespace, Pod: pod.Name, Container: c.Name}
+				}
+			}
+		}
+	}
+	return owners, nil
+}
+
+// CheckpointOwners lists owners from kubelet checkpoint file, or a fixture
+// in the same format.
+func CheckpointOwners(file string) OwnerSource {
+	return checkpointOwners(file)
+}
+
+type checkpointOwners string
+
+type checkpoint struct {
+	Data struct {
+		PodDeviceEntries []struct {
+			PodUID        string
+			ContainerName string
+			ResourceName  string
+			// DeviceIDs is a list, or lists keyed by NUMA node since
+			// kubernetes 1.20.
+			DeviceIDs json.RawMessage
+		}
+	}
+}
+
+func (f checkpointOwners) Owners(resourceName string) (map[string]Owner, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", f, err)
+	}
+
+	owners := map[string]Owner{}
+	for _, e := range cp.Data.PodDeviceEntries {
+		if e.ResourceName != resourceName {
+			continue
+		}
+		var ids []string
+		if err = json.Unmarshal(e.DeviceIDs, &ids); err != nil {
+			var byNode map[string][]string
+			if err = json.Unmarshal(e.DeviceIDs, &byNode); err != nil {
+				return nil, fmt.Errorf("invalid devices of pod %s in checkpoint %s: %v", e.PodUID, f, err)
+			}
+			for _, nodeIDs := range byNode {
+				ids = append(ids, nodeIDs...)
+			}
+		}
+		for _, id := range ids {
+			owners[id] = Owner{PodUID: e.PodUID, Container: e.ContainerName}
+		}
+	}
+	return owners, nil
+}
+
+// ownerIndex keeps owners of devices of a resource, refreshed from source
+// every interval.
+type ownerIndex struct {
+	resourceName string
+	source       OwnerSource
+	interval     time.Duration
+	ledger       *allocationLedger
+	// refreshed is called with owners after each refresh.
+	refreshed func(map[string]Owner)
+
+	// refreshLock serializes refreshes.
+	refreshLock sync.Mutex
+
+	lock   sync.RWMutex
+	owners map[string]Owner
+}
+
+func newOwnerIndex(conf Config, ledger *allocationLedger) *ownerIndex {
+	source := conf.OwnerSource
+	if source == nil {
+		socket := conf.PodResourcesSocket
+		if socket == "" {
+			socket = DefaultPodResourcesSocket
+		}
+		source = PodResourcesOwners(socket)
+	}
+	interval := conf.OwnerInterval
+	if interval <= 0 {
+		interval = defaultOwnerInterval
+	}
+	return &ownerIndex{
+		resourceName: conf.ResourceName,
+		source:       source,
+		interval:     interval,
+		ledger:       ledger,
+		owners:       map[string]Owner{},
+	}
+}
+
+func (x *ownerIndex) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(x.interval)
+	defer ticker.Stop()
+	for {
+		if err := x.refresh(); err != nil {
+			log.Printf("Could not list owners of %s: %v", x.resourceName, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh lists owners from source, and attributes allocations in ledger
+// to new owners.
+func (x *ownerIndex) refresh() error {
+	x.refreshLock.Lock()
+	defer x.refreshLock.Unlock()
+
+	owners, err := x.source.Owners(x.resourceName)
+	if err != nil {
+		return err
+	}
+	x.lock.Lock()
+	changed := map[string]Owner{}
+	for id, o := range owners {
+		if old, ok := x.owners[id]; !ok || old != o {
+			changed[id] = o
+		}
+	}
+	x.owners = owners
+	x.lock.Unlock()
+
+	if len(changed) > 0 {
+		x.ledger.Attribute(changed)
+	}
+	if x.refreshed != nil {
+		x.refreshed(owners)
+	}
+	return nil
+}
+
+// Get returns owner of device with id.
+func (x *ownerIndex) Get(id string) (Owner, bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	o, ok := x.owners[id]
+	return o, ok
+}
+
+// List returns owners of all devices assigned.
+func (x *ownerIndex) List() map[string]Owner {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	owners := make(map[string]Owner, len(x.owners))
+	for id, o := range x.owners {
+		owners[id] = o
+	}
+	return owners
+}
+
+// OwnerOf returns the container device id of resourceName running in this
+// process is assigned to, for logging or labelling metrics in callbacks.
+// Set Config.OwnerSource or ReleaseFunc to track owners. It never waits for
+// owners to be refreshed, so a device just allocated may not be found.
+func OwnerOf(resourceName, id string) (Owner, bool) {
+	for _, s := range runningStates() {
+		if s.resourceName == resourceName && s.owners != nil {
+			return s.owners.Get(id)
+		}
+	}
+	return Owner{}, false
+}
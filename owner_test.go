@@ -0,0 +1,154 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	entry := func(uid, container, resource, ids string) string {
+		return fmt.Sprintf(`{"PodUID": %q, "ContainerName": %q, "ResourceName": %q, "DeviceIDs": %s}`, uid, container, resource, ids)
+	}
+	checkpoint := func(entries ...string) string {
+		return `{"Data": {"PodDeviceEntries": [` + strings.Join(entries, ", ") + `], "RegisteredDevices": {}}, "Checksum": 1}`
+	}
+
+	for _, tc := range []struct {
+		name string
+		data string
+		want map[string]Owner
+		err  string
+	}{
+		{
+			name: "list",
+			data: checkpoint(
+				entry("uid-a", "main", "example.com/gpu", `["gpu-0", "gpu-1"]`),
+				entry("uid-b", "main", "example.com/nic", `["nic-0"]`),
+			),
+			want: map[string]Owner{
+				"gpu-0": {PodUID: "uid-a", Container: "main"},
+				"gpu-1": {PodUID: "uid-a", Container: "main"},
+			},
+		},
+		{
+			name: "by NUMA node",
+			data: checkpoint(
+				entry("uid-a", "main", "example.com/gpu", `{"0": ["gpu-0"], "1": ["gpu-2"]}`),
+				entry("uid-b", "side", "example.com/gpu", `{"-1": ["gpu-1"]}`),
+			),
+			want: map[string]Owner{
+				"gpu-0": {PodUID: "uid-a", Container: "main"},
+				"gpu-1": {PodUID: "uid-b", Container: "side"},
+				"gpu-2": {PodUID: "uid-a", Container: "main"},
+			},
+		},
+		{name: "empty", data: checkpoint(), want: map[string]Owner{}},
+		{name: "invalid", data: `{"Data": [`, err: "invalid checkpoint"},
+		{
+			name: "invalid devices",
+			data: checkpoint(entry("uid-a", "main", "example.com/gpu", `"gpu-0"`)),
+			err:  "invalid devices of pod uid-a",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, "checkpoint")
+			if err := ioutil.WriteFile(file, []byte(tc.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			owners, err := CheckpointOwners(file).Owners("example.com/gpu")
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(owners, tc.want) {
+				t.Errorf("got owners %v, want %v", owners, tc.want)
+			}
+		})
+	}
+
+	if _, err := CheckpointOwners(filepath.Join(dir, "missing")).Owners("example.com/gpu"); !os.IsNotExist(err) {
+		t.Errorf("got error %v for missing checkpoint, want not exist", err)
+	}
+}
+
+func TestOwnerString(t *testing.T) {
+	for _, tc := range []struct {
+		owner Owner
+		want  string
+	}{
+		{Owner{Namespace: "default", Pod: "a", PodUID: "uid-a", Container: "main"}, "default/a/main"},
+		{Owner{PodUID: "uid-a", Container: "main"}, "uid-a/main"},
+	} {
+		if got := tc.owner.String(); got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestOwnerIndexRefresh(t *testing.T) {
+	source := &fakeOwners{}
+	ledger := newAllocationLedger()
+	x := newOwnerIndex(Config{ResourceName: "example.com/gpu", OwnerSource: source}, ledger)
+	var refreshed []map[string]Owner
+	x.refreshed = func(owners map[string]Owner) { refreshed = append(refreshed, owners) }
+
+	a := Owner{Namespace: "default", Pod: "a", Container: "main"}
+	b := Owner{Namespace: "default", Pod: "b", Container: "main"}
+	ledger.Record([]string{"gpu-0", "gpu-1"}, nil)
+	ledger.Record([]string{"gpu-2"}, fmt.Errorf("failed"))
+	source.owners = map[string]Owner{"gpu-0": a, "gpu-1": a}
+	if err := x.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// gpu-1 is allocated again, to b.
+	ledger.Record([]string{"gpu-1"}, nil)
+	source.owners = map[string]Owner{"gpu-0": a, "gpu-1": b, "gpu-2": b}
+	if err := x.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range ledger.Records() {
+		got = append(got, fmt.Sprintf("%v %v", r.Devices, r.Owners))
+	}
+	want := []string{
+		"[gpu-0 gpu-1] map[gpu-0:default/a/main gpu-1:default/a/main]",
+		// Failed allocations have no owners.
+		"[gpu-2] map[]",
+		"[gpu-1] map[gpu-1:default/b/main]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got allocations\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if o, ok := x.Get("gpu-1"); !ok || o != b {
+		t.Errorf("got owner %v of gpu-1, want %v", o, b)
+	}
+	if len(refreshed) != 2 || !reflect.DeepEqual(refreshed[1], x.List()) {
+		t.Errorf("refreshed is called with %v", refreshed)
+	}
+
+	// Owners are kept if source fails.
+	source.err = fmt.Errorf("kubelet is down")
+	if err := x.refresh(); err == nil {
+		t.Errorf("refreshed with source failing")
+	}
+	if len(x.List()) != 3 || len(refreshed) != 2 {
+		t.Errorf("got owners %v after source failed", x.List())
+	}
+}
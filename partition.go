@@ -0,0 +1,189 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PartitionRule advertises devices matching Selector as ResourceName.
+type PartitionRule struct {
+	ResourceName string
+	SocketName   string
+	// Selector matches device attributes in label selector syntax,
+	// such as "model in (a100,h100),memory>16".
+	Selector string
+}
+
+// PartitionConfig splits devices from one source into several resources.
+type PartitionConfig struct {
+	Devices <-chan []*Device
+	// Rules are tried in order, and a device goes to the first rule it
+	// matches. Devices matching no rule are not advertised.
+	Rules []PartitionRule
+	// Template is the config of each resource, with ResourceName,
+	// SocketName and source taken from rules.
+	Template Config
+}
+
+type partition struct {
+	selector labels.Selector
+	config   Config
+	update   chan []*Device
+	sigCh    chan bool
+}
+
+func (c *PartitionConfig) partitions() ([]*partition, error) {
+	if c.Devices == nil {
+		return nil, fmt.Errorf("devices cannot be empty")
+	}
+
+	var parts []*partition
+	resources, sockets := map[string]bool{}, map[string]bool{}
+	for _, rule := range c.Rules {
+		if resources[rule.ResourceName] || sockets[rule.SocketName] {
+			return nil, fmt.Errorf("duplicated partition %s on %s", rule.ResourceName, rule.SocketName)
+		}
+		resources[rule.ResourceName], sockets[rule.SocketName] = true, true
+
+		selector, err := labels.Parse(rule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of %s: %v", rule.ResourceName, err)
+		}
+
+		update := make(chan []*Device, 1)
+		config := c.Template
+		config.ResourceName = rule.ResourceName
+		config.SocketName = rule.SocketName
+		config.Update = nil
+		config.Devices = update
+		// Partitions shall not overwrite each other's feature file and
+		// condition.
+		if inv := c.Template.Inventory; inv != nil && inv.FeatureFile != "" {
+			copied := *inv
+			copied.FeatureFile = partitionName(inv.FeatureFile, rule.ResourceName)
+			config.Inventory = &copied
+		}
+		if rep := c.Template.Reporter; rep != nil && rep.ConditionType != "" {
+			copied := *rep
+			copied.ConditionType = v1.NodeConditionType(partitionName(string(rep.ConditionType), rule.ResourceName))
+			config.Reporter = &copied
+		}
+		if err = config.Validate(); err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, &partition{
+			selector: selector,
+			config:   config,
+			update:   update,
+			sigCh:    make(chan bool, 1),
+		})
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("rules cannot be empty")
+	}
+	return parts, nil
+}
+
+// RunPartitioned runs a plugin for each rule like Run, and returns when all
+// of them exit. Signals from sigCh are passed to every plugin, and all of
+// them exit if any fails.
+func RunPartitioned(config PartitionConfig, sigCh <-chan bool) error {
+	parts, err := config.partitions()
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, len(parts))
+	for _, part := range parts {
+		go func(part *partition) {
+			errCh <- Run(part.config, part.sigCh)
+		}(part)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go splitDevices(config.Devices, parts, stop)
+
+	var firstErr error
+	for running := len(parts); running > 0; {
+		select {
+		case err := <-errCh:
+			running--
+			if err != nil && firstErr == nil {
+				log.Println("Partition exits:", err)
+				firstErr = err
+				signalPartitions(parts, false)
+			}
+		case sig := <-sigCh:
+			signalPartitions(parts, sig)
+		}
+	}
+	return firstErr
+}
+
+// partitionName returns name suffixed by resourceName of a partition, such
+// as gpu-example.com_a100 for gpu of example.com/a100.
+func partitionName(name, resourceName string) string {
+	return name + "-" + strings.Replace(resourceName, "/", "_", -1)
+}
+
+func signalPartitions(parts []*partition, sig bool) {
+	for _, part := range parts {
+		// Replace the pending signal if it is not consumed yet.
+		select {
+		case <-part.sigCh:
+		default:
+		}
+		part.sigCh <- sig
+	}
+}
+
+// splitDevices sends devices from source to the partition of the first rule
+// they match, until stop is closed or source ends. Each partition gets the
+// latest devices, while older ones it has not received are dropped.
+func splitDevices(source <-chan []*Device, parts []*partition, stop <-chan struct{}) {
+	defer func() {
+		for _, part := range parts {
+			close(part.update)
+		}
+	}()
+
+	for {
+		var devs []*Device
+		select {
+		case <-stop:
+			return
+		case updated, ok := <-source:
+			if !ok {
+				return
+			}
+			devs = updated
+		}
+
+		split := make([][]*Device, len(parts))
+		for _, d := range devs {
+			for i, part := range parts {
+				if part.selector.Matches(labels.Set(d.Attrs)) {
+					split[i] = append(split[i], d)
+					break
+				}
+			}
+		}
+
+		for i, part := range parts {
+			// Replace the pending update if it is not consumed yet, so
+			// that a stalled partition does not block the others.
+			select {
+			case <-part.update:
+			default:
+			}
+			part.update <- split[i]
+		}
+	}
+}
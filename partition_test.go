@@ -0,0 +1,217 @@
+package deviceplugin
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestPartitionNames(t *testing.T) {
+	conf := PartitionConfig{
+		Devices: make(chan []*Device),
+		Rules: []PartitionRule{
+			{ResourceName: "example.com/a100", SocketName: "a100.sock"},
+			{ResourceName: "example.com/h100", SocketName: "h100.sock"},
+		},
+		Template: Config{
+			Inventory: &InventoryConfig{FeatureFile: "/features.d/example-gpu"},
+			Reporter:  &ReporterConfig{Client: &recordingClient{}, NodeName: "node-0", ConditionType: "GPUHealthy"},
+		},
+	}
+	parts, err := conf.partitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files, conditions []string
+	for _, part := range parts {
+		files = append(files, part.config.Inventory.FeatureFile)
+		conditions = append(conditions, string(part.config.Reporter.ConditionType))
+	}
+	if want := []string{"/features.d/example-gpu-example.com_a100", "/features.d/example-gpu-example.com_h100"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got feature files %v, want %v", files, want)
+	}
+	if want := []string{"GPUHealthy-example.com_a100", "GPUHealthy-example.com_h100"}; !reflect.DeepEqual(conditions, want) {
+		t.Errorf("got conditions %v, want %v", conditions, want)
+	}
+	if conf.Template.Inventory.FeatureFile != "/features.d/example-gpu" || conf.Template.Reporter.ConditionType != "GPUHealthy" {
+		t.Errorf("template is changed to %+v", conf.Template)
+	}
+}
+
+func TestPartitionRules(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules []PartitionRule
+		want  string
+	}{
+		{name: "no rules", want: "rules cannot be empty"},
+		{
+			name: "duplicated resource",
+			rules: []PartitionRule{
+				{ResourceName: "example.com/a", SocketName: "a.sock"},
+				{ResourceName: "example.com/a", SocketName: "b.sock"},
+			},
+			want: "duplicated partition",
+		},
+		{
+			name: "duplicated socket",
+			rules: []PartitionRule{
+				{ResourceName: "example.com/a", SocketName: "a.sock"},
+				{ResourceName: "example.com/b", SocketName: "a.sock"},
+			},
+			want: "duplicated partition",
+		},
+		{
+			name:  "invalid selector",
+			rules: []PartitionRule{{ResourceName: "example.com/a", SocketName: "a.sock", Selector: "model in ("}},
+			want:  "invalid selector of example.com/a",
+		},
+		{
+			name:  "invalid resource",
+			rules: []PartitionRule{{ResourceName: "a", SocketName: "a.sock"}},
+			want:  "not a valid resource name",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := PartitionConfig{Devices: make(chan []*Device), Rules: tc.rules}
+			_, err := conf.partitions()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitDevices(t *testing.T) {
+	var parts []*partition
+	for _, selector := range []string{"model=a100", "model in (a100,h100)", "model!=a100"} {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, &partition{selector: s, update: make(chan []*Device, 1)})
+	}
+	source := make(chan []*Device)
+	done := make(chan struct{})
+	go func() {
+		splitDevices(source, parts, make(chan struct{}))
+		close(done)
+	}()
+
+	receive := func(part *partition) string {
+		select {
+		case devs := <-part.update:
+			var ids []string
+			for _, d := range devs {
+				ids = append(ids, d.ID)
+			}
+			return strings.Join(ids, ",")
+		case <-time.After(5 * time.Second):
+			t.Fatal("no devices sent")
+			return ""
+		}
+	}
+	send := func(models ...string) {
+		var devs []*Device
+		for i, m := range models {
+			devs = append(devs, &Device{ID: fmt.Sprintf("%s-%d", m, i), Attrs: map[string]string{"model": m}})
+		}
+		select {
+		case source <- devs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("devices are not received")
+		}
+	}
+
+	// The last partition is stalled, while the others get every update.
+	send("a100", "h100", "t4")
+	if got := receive(parts[0]); got != "a100-0" {
+		t.Errorf("got %s in first partition, want a100-0", got)
+	}
+	if got := receive(parts[1]); got != "h100-1" {
+		t.Errorf("got %s in second partition, want h100-1", got)
+	}
+	for i := 0; i < 3; i++ {
+		send("h100", "a100")
+		if got := receive(parts[1]); got != "h100-0" {
+			t.Errorf("got %s in second partition, want h100-0", got)
+		}
+	}
+	send("t4")
+	// Ending source waits for the last update to be sent.
+	close(source)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("splitDevices does not return when source ends")
+	}
+	if got := receive(parts[2]); got != "t4-0" {
+		t.Errorf("got %s in stalled partition, want only the latest t4-0", got)
+	}
+	for i, part := range parts {
+		for range part.update {
+		}
+		if _, ok := <-part.update; ok {
+			t.Errorf("update of partition %d is not closed", i)
+		}
+	}
+}
+
+// TestRunPartitioned runs partitions in dry run, until one signal stops all.
+func TestRunPartitioned(t *testing.T) {
+	var lock sync.Mutex
+	var out bytes.Buffer
+	devices := make(chan []*Device, 1)
+	sigCh := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- RunPartitioned(PartitionConfig{
+			Devices: devices,
+			Rules: []PartitionRule{
+				{ResourceName: "example.com/a100", SocketName: "a100.sock", Selector: "model=a100"},
+				{ResourceName: "example.com/other", SocketName: "other.sock"},
+			},
+			Template: Config{DryRun: &DryRunConfig{Output: writerFunc(func(p []byte) (int, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				return out.Write(p)
+			})}},
+		}, sigCh)
+	}()
+
+	devices <- []*Device{
+		{ID: "gpu-0", Health: pluginapi.Healthy, Attrs: map[string]string{"model": "a100"}},
+		{ID: "gpu-1", Health: pluginapi.Healthy, Attrs: map[string]string{"model": "t4"}},
+		{ID: "gpu-2", Health: pluginapi.Unhealthy, Attrs: map[string]string{"model": "t4"}},
+	}
+	for _, want := range []string{"Devices of example.com/a100: 1, 1 healthy", "Devices of example.com/other: 2, 1 healthy"} {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			lock.Lock()
+			found := strings.Contains(out.String(), want)
+			lock.Unlock()
+			if found {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %q", want)
+			}
+		}
+	}
+
+	sigCh <- false
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("partitions do not exit by signal")
+	}
+}
@@ -0,0 +1,150 @@
+package deviceplugin
+
+import (
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GroupFunc returns the group of a device, such as the switch or NUMA node
+// it is attached to. Devices in the same group are regarded as close.
+type GroupFunc func(id string) string
+
+// PackPolicy prefers devices in as few groups as possible. Groups of devices
+// which must be included are filled first, then the smallest group which can
+// hold the rest, or the largest one if no group is big enough.
+func PackPolicy(group GroupFunc) PreferredAllocationFunc {
+	return func(available, mustInclude []string, size int) ([]string, error) {
+		chosen, groups, err := splitCandidates(group, available, mustInclude, size)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if len(chosen) >= size {
+				break
+			}
+			if g.used {
+				chosen = g.take(chosen, size-len(chosen))
+			}
+		}
+
+		for len(chosen) < size {
+			g := bestFit(groups, size-len(chosen))
+			if g == nil {
+				break
+			}
+			chosen = g.take(chosen, size-len(chosen))
+		}
+		return chosen, nil
+	}
+}
+
+// SpreadPolicy prefers devices in as many groups as possible. Devices are
+// taken one per group in turn, starting with groups not used by the devices
+// which must be included.
+func SpreadPolicy(group GroupFunc) PreferredAllocationFunc {
+	return func(available, mustInclude []string, size int) ([]string, error) {
+		chosen, groups, err := splitCandidates(group, available, mustInclude, size)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(groups, func(i, j int) bool {
+			if groups[i].used != groups[j].used {
+				return !groups[i].used
+			}
+			return len(groups[i].ids) > len(groups[j].ids)
+		})
+
+		for len(chosen) < size {
+			taken := false
+			for _, g := range groups {
+				if len(chosen) >= size {
+					break
+				}
+				if len(g.ids) > 0 {
+					chosen = g.take(chosen, 1)
+					taken = true
+				}
+			}
+			if !taken {
+				break
+			}
+		}
+		return chosen, nil
+	}
+}
+
+type candidateGroup struct {
+	ids []string
+	// used is true if any device which must be included is in this group.
+	used bool
+}
+
+func (g *candidateGroup) take(chosen []string, n int) []string {
+	if n > len(g.ids) {
+		n = len(g.ids)
+	}
+	chosen = append(chosen, g.ids[:n]...)
+	g.ids = g.ids[n:]
+	return chosen
+}
+
+// splitCandidates returns the devices which must be included, and the other
+// available devices grouped in the order they first appear. It fails if
+// more than size devices must be included, since no answer has them all.
+func splitCandidates(group GroupFunc, available, mustInclude []string, size int) ([]string, []*candidateGroup, error) {
+	chosen := make([]string, 0, len(mustInclude))
+	included := make(map[string]bool, len(mustInclude))
+	for _, id := range mustInclude {
+		if !included[id] {
+			included[id] = true
+			chosen = append(chosen, id)
+		}
+	}
+	if len(chosen) > size {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "%d devices must be included, more than %d requested", len(chosen), size)
+	}
+
+	var groups []*candidateGroup
+	index := map[string]*candidateGroup{}
+	for _, id := range available {
+		if included[id] {
+			continue
+		}
+		name := group(id)
+		g, ok := index[name]
+		if !ok {
+			g = &candidateGroup{}
+			index[name] = g
+			groups = append(groups, g)
+		}
+		g.ids = append(g.ids, id)
+	}
+
+	for _, id := range chosen {
+		if g, ok := index[group(id)]; ok {
+			g.used = true
+		}
+	}
+	return chosen, groups, nil
+}
+
+func bestFit(groups []*candidateGroup, n int) *candidateGroup {
+	var fit, largest *candidateGroup
+	for _, g := range groups {
+		if len(g.ids) == 0 {
+			continue
+		}
+		if len(g.ids) >= n && (fit == nil || len(g.ids) < len(fit.ids)) {
+			fit = g
+		}
+		if largest == nil || len(g.ids) > len(largest.ids) {
+			largest = g
+		}
+	}
+	if fit != nil {
+		return fit
+	}
+	return largest
+}
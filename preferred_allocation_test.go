@@ -0,0 +1,188 @@
+package deviceplugin
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// groupByPrefix groups devices like "a0" and "a1" by their first letter.
+func groupByPrefix(id string) string {
+	return id[:1]
+}
+
+type policyCase struct {
+	name        string
+	available   []string
+	mustInclude []string
+	size        int
+	want        []string
+	wantErr     bool
+}
+
+func runPolicyCases(t *testing.T, policy PreferredAllocationFunc, cases []policyCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := policy(c.available, c.mustInclude, c.size)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPackPolicy(t *testing.T) {
+	runPolicyCases(t, PackPolicy(groupByPrefix), []policyCase{
+		{
+			name:      "smallest group which fits",
+			available: []string{"a0", "a1", "a2", "b0", "b1"},
+			size:      2,
+			want:      []string{"b0", "b1"},
+		},
+		{
+			name:        "group of included devices first",
+			available:   []string{"a0", "a1", "b0", "b1", "b2"},
+			mustInclude: []string{"a0"},
+			size:        2,
+			want:        []string{"a0", "a1"},
+		},
+		{
+			name:        "rest after group of included devices",
+			available:   []string{"a0", "a1", "b0", "b1", "b2"},
+			mustInclude: []string{"a0"},
+			size:        4,
+			want:        []string{"a0", "a1", "b0", "b1"},
+		},
+		{
+			name:      "groups smaller than request",
+			available: []string{"a0", "a1", "b0", "b1", "b2", "c0"},
+			size:      4,
+			want:      []string{"b0", "b1", "b2", "c0"},
+		},
+		{
+			name:      "fewer available than request",
+			available: []string{"a0", "b0"},
+			size:      3,
+			want:      []string{"a0", "b0"},
+		},
+		{
+			name:        "duplicate included devices",
+			available:   []string{"a0", "a1"},
+			mustInclude: []string{"a0", "a0"},
+			size:        2,
+			want:        []string{"a0", "a1"},
+		},
+		{
+			name:        "all included",
+			available:   []string{"a0", "b0"},
+			mustInclude: []string{"b0", "a0"},
+			size:        2,
+			want:        []string{"b0", "a0"},
+		},
+		{
+			name:        "included devices not available",
+			available:   []string{"a1", "b0", "b1"},
+			mustInclude: []string{"a0"},
+			size:        2,
+			want:        []string{"a0", "a1"},
+		},
+		{
+			name:      "none available",
+			available: nil,
+			size:      2,
+			want:      []string{},
+		},
+		{
+			name:      "none requested",
+			available: []string{"a0"},
+			size:      0,
+			want:      []string{},
+		},
+		{
+			name:        "more included than request",
+			available:   []string{"a0", "a1", "b0"},
+			mustInclude: []string{"a0", "b0"},
+			size:        1,
+			wantErr:     true,
+		},
+	})
+}
+
+func TestSpreadPolicy(t *testing.T) {
+	runPolicyCases(t, SpreadPolicy(groupByPrefix), []policyCase{
+		{
+			name:      "one per group",
+			available: []string{"a0", "a1", "b0", "b1", "c0"},
+			size:      3,
+			want:      []string{"a0", "b0", "c0"},
+		},
+		{
+			name:        "groups of included devices last",
+			available:   []string{"a0", "a1", "b0", "b1", "c0"},
+			mustInclude: []string{"a0"},
+			size:        3,
+			want:        []string{"a0", "b0", "c0"},
+		},
+		{
+			name:      "round robin",
+			available: []string{"a0", "a1", "b0", "b1"},
+			size:      4,
+			want:      []string{"a0", "b0", "a1", "b1"},
+		},
+		{
+			name:      "groups smaller than request",
+			available: []string{"a0", "b0", "b1", "b2"},
+			size:      3,
+			want:      []string{"b0", "a0", "b1"},
+		},
+		{
+			name:      "fewer available than request",
+			available: []string{"a0", "b0"},
+			size:      3,
+			want:      []string{"a0", "b0"},
+		},
+		{
+			name:        "included devices not available",
+			available:   []string{"a1", "b0", "b1"},
+			mustInclude: []string{"a0", "a0"},
+			size:        3,
+			want:        []string{"a0", "b0", "a1"},
+		},
+		{
+			name:      "none requested",
+			available: []string{"a0"},
+			size:      0,
+			want:      []string{},
+		},
+		{
+			name:        "more included than request",
+			available:   []string{"a0", "a1", "b0"},
+			mustInclude: []string{"a0", "b0"},
+			size:        1,
+			wantErr:     true,
+		},
+	})
+}
+
+func TestPolicyErrors(t *testing.T) {
+	for name, policy := range map[string]PreferredAllocationFunc{
+		"pack":   PackPolicy(groupByPrefix),
+		"spread": SpreadPolicy(groupByPrefix),
+	} {
+		_, err := policy([]string{"a0", "b0"}, []string{"a0", "b0"}, 1)
+		if code := grpc.Code(err); code != codes.InvalidArgument {
+			t.Errorf("%s: got code %v of error %v, want %v", name, code, err, codes.InvalidArgument)
+		}
+	}
+}
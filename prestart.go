@@ -0,0 +1,169 @@
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// DeviceHook initializes device with id, such as loading firmware or
+// clearing its state. It shall return once ctx is done.
+type DeviceHook func(ctx context.Context, id string) error
+
+// PreStartHooks initializes each device of a container before it starts,
+// devices in parallel. Set PreStartFunc() to Config.PreStartFunc.
+type PreStartHooks struct {
+	// Command, if set, is run for each device, with device ID as its last
+	// argument and in env DEVICE_ID. Its output is logged.
+	Command []string
+	// Func, if set, is called for each device after Command succeeds.
+	Func DeviceHook
+	// Timeout bounds initialization of all devices, a little less than
+	// kubelet waits for PreStartContainer by default. DeviceTimeout bounds
+	// each device, Timeout by default.
+	Timeout       time.Duration
+	DeviceTimeout time.Duration
+	// Parallelism limits devices initialized at once, unlimited if 0.
+	Parallelism int
+}
+
+// preStartMargin is left of kubelet timeout to return an error before
+// kubelet gives up.
+const preStartMargin = 2 * time.Second
+
+func (h PreStartHooks) timeout() time.Duration {
+	if h.Timeout <= 0 {
+		return pluginapi.KubeletPreStartContainerRPCTimeoutInSecs*time.Second - preStartMargin
+	}
+	return h.Timeout
+}
+
+func (h PreStartHooks) deviceTimeout() time.Duration {
+	if h.DeviceTimeout <= 0 || h.DeviceTimeout > h.timeout() {
+		return h.timeout()
+	}
+	return h.DeviceTimeout
+}
+
+// PreStartFunc initializes devices of a container, and fails with the code
+// of the first failing device, naming all failing devices.
+func (h PreStartHooks) PreStartFunc() PreStartFunc {
+	return func(ids []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+		defer cancel()
+
+		var slots chan struct{}
+		if h.Parallelism > 0 {
+			slots = make(chan struct{}, h.Parallelism)
+		}
+		errs := make([]error, len(ids))
+		var wg sync.WaitGroup
+		for i, id := range ids {
+			wg.Add(1)
+			go func(i int, id string) {
+				defer wg.Done()
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+						defer func() { <-slots }()
+					case <-ctx.Done():
+						errs[i] = status.Errorf(codes.DeadlineExceeded, "not started in %v", h.timeout())
+						return
+					}
+				}
+				errs[i] = h.initDevice(ctx, id)
+			}(i, id)
+		}
+		wg.Wait()
+
+		var failed []string
+		code := codes.OK
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			s, ok := status.FromError(err)
+			if !ok {
+				s = status.New(codes.Unknown, err.Error())
+			}
+			if code == codes.OK {
+				code = s.Code()
+			}
+			failed = append(failed, fmt.Sprintf("device %s: %s", ids[i], s.Message()))
+		}
+		if len(failed) > 0 {
+			return status.Errorf(code, "prestart failed for %s", strings.Join(failed, "; "))
+		}
+		return nil
+	}
+}
+
+func (h PreStartHooks) initDevice(all context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(all, h.deviceTimeout())
+	defer cancel()
+
+	var err error
+	if len(h.Command) > 0 {
+		err = h.runCommand(ctx, id)
+	}
+	if err == nil && h.Func != nil {
+		done := make(chan error, 1)
+		go func() { done <- h.Func(ctx, id) }()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	switch {
+	case all.Err() != nil:
+		return status.Errorf(codes.DeadlineExceeded, "not initialized in %v, the timeout of all devices", h.timeout())
+	case ctx.Err() != nil:
+		return status.Errorf(codes.DeadlineExceeded, "not initialized in %v", h.deviceTimeout())
+	}
+	return err
+}
+
+// runCommand runs Command for device id, and logs its output.
+func (h PreStartHooks) runCommand(ctx context.Context, id string) error {
+	argv := append(append([]string(nil), h.Command...), id)
+	out := &tailBuffer{max: maxExecOutput}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "DEVICE_ID="+id)
+	// Output is written by one goroutine at a time, since it's the same
+	// writer.
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	wait, err := startCommand(ctx, cmd)
+	if err == nil {
+		err = wait()
+	}
+	name := filepath.Base(argv[0]) + " " + id
+	logLines(name, strings.NewReader(out.String()))
+	if err == nil || ctx.Err() != nil {
+		// Timeout is reported by caller.
+		return err
+	}
+
+	if _, ok := err.(*exec.ExitError); !ok {
+		return status.Errorf(codes.Unavailable, "could not run %s: %v", argv[0], err)
+	}
+	return status.Errorf(codes.Unknown, "%s failed: %v: %s", argv[0], err, lastLine(out.String()))
+}
+
+// lastLine returns the last non-empty line of output, which is usually the
+// error.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
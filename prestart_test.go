@@ -0,0 +1,193 @@
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPreStartTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		hooks         PreStartHooks
+		timeout       time.Duration
+		deviceTimeout time.Duration
+	}{
+		// Kubelet waits 30s by default.
+		{hooks: PreStartHooks{}, timeout: 28 * time.Second, deviceTimeout: 28 * time.Second},
+		{hooks: PreStartHooks{DeviceTimeout: 5 * time.Second}, timeout: 28 * time.Second, deviceTimeout: 5 * time.Second},
+		{hooks: PreStartHooks{Timeout: 10 * time.Second}, timeout: 10 * time.Second, deviceTimeout: 10 * time.Second},
+		{hooks: PreStartHooks{Timeout: 10 * time.Second, DeviceTimeout: time.Minute}, timeout: 10 * time.Second, deviceTimeout: 10 * time.Second},
+	} {
+		if got := tc.hooks.timeout(); got != tc.timeout {
+			t.Errorf("timeout of %+v is %v, want %v", tc.hooks, got, tc.timeout)
+		}
+		if got := tc.hooks.deviceTimeout(); got != tc.deviceTimeout {
+			t.Errorf("device timeout of %+v is %v, want %v", tc.hooks, got, tc.deviceTimeout)
+		}
+	}
+}
+
+func TestPreStartHooks(t *testing.T) {
+	// blocking returns a hook blocking until ctx is done for ids in block,
+	// and failing with err for ids in fail.
+	blocking := func(block []string, fail map[string]error) DeviceHook {
+		return func(ctx context.Context, id string) error {
+			for _, b := range block {
+				if b == id {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+			}
+			return fail[id]
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		hooks PreStartHooks
+		ids   []string
+		code  codes.Code
+		// want are failures reported, in order of ids, or in reverse if
+		// anyOrder is set.
+		want     []string
+		anyOrder bool
+	}{
+		{
+			name:  "succeeded",
+			hooks: PreStartHooks{Func: blocking(nil, nil)},
+			ids:   []string{"dev-0", "dev-1"},
+		},
+		{
+			name:  "device timeout",
+			hooks: PreStartHooks{Func: blocking([]string{"dev-1"}, nil), DeviceTimeout: 100 * time.Millisecond},
+			ids:   []string{"dev-0", "dev-1", "dev-2"},
+			code:  codes.DeadlineExceeded,
+			want:  []string{"device dev-1: not initialized in 100ms"},
+		},
+		{
+			name:  "timeout of all",
+			hooks: PreStartHooks{Func: blocking([]string{"dev-0", "dev-1"}, nil), Timeout: 100 * time.Millisecond},
+			ids:   []string{"dev-0", "dev-1"},
+			code:  codes.DeadlineExceeded,
+			want: []string{
+				"device dev-0: not initialized in 100ms, the timeout of all devices",
+				"device dev-1: not initialized in 100ms, the timeout of all devices",
+			},
+		},
+		{
+			name:  "not started",
+			hooks: PreStartHooks{Func: blocking([]string{"dev-0", "dev-1"}, nil), Timeout: 100 * time.Millisecond, Parallelism: 1},
+			ids:   []string{"dev-0", "dev-1"},
+			code:  codes.DeadlineExceeded,
+			// Either device may get the only slot.
+			want:     []string{"not initialized in 100ms", "not started in 100ms"},
+			anyOrder: true,
+		},
+		{
+			name: "failed",
+			hooks: PreStartHooks{Func: blocking(nil, map[string]error{
+				"dev-1": status.Errorf(codes.FailedPrecondition, "firmware missing"),
+				"dev-2": fmt.Errorf("reset failed"),
+			})},
+			ids:  []string{"dev-0", "dev-1", "dev-2"},
+			code: codes.FailedPrecondition,
+			want: []string{"device dev-1: firmware missing", "device dev-2: reset failed"},
+		},
+		{
+			name:  "command failed",
+			hooks: PreStartHooks{Command: sh(`[ "$DEVICE_ID" != dev-1 ] || { echo "no firmware for $DEVICE_ID" >&2; exit 2; }`)},
+			ids:   []string{"dev-0", "dev-1"},
+			code:  codes.Unknown,
+			want:  []string{"device dev-1: sh failed: exit status 2: no firmware for dev-1"},
+		},
+		{
+			name: "func after command",
+			hooks: PreStartHooks{
+				Command: sh(`[ "$DEVICE_ID" != dev-0 ]`),
+				Func:    blocking(nil, map[string]error{"dev-0": fmt.Errorf("called"), "dev-1": fmt.Errorf("called")}),
+			},
+			ids:  []string{"dev-0", "dev-1"},
+			code: codes.Unknown,
+			want: []string{"device dev-0: sh failed: exit status 1", "device dev-1: called"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.hooks.PreStartFunc()(tc.ids)
+			if code := grpc.Code(err); code != tc.code {
+				t.Fatalf("got code %v of error %v, want %v", code, err, tc.code)
+			}
+			if err == nil {
+				return
+			}
+			s, _ := status.FromError(err)
+			msg := strings.TrimPrefix(s.Message(), "prestart failed for ")
+			failures := strings.Split(msg, "; ")
+			if len(failures) != len(tc.want) {
+				t.Fatalf("got failures %q, want %q", failures, tc.want)
+			}
+			for i, f := range failures {
+				if !strings.Contains(f, tc.want[i]) && !(tc.anyOrder && strings.Contains(f, tc.want[len(tc.want)-1-i])) {
+					t.Errorf("got failure %q, want %q", f, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPreStartParallel(t *testing.T) {
+	for _, tc := range []struct {
+		parallelism int
+		want        int
+	}{
+		{want: 4},
+		{parallelism: 2, want: 2},
+	} {
+		var lock sync.Mutex
+		running, most := 0, 0
+		// Every hook waits for the others to start, up to the limit.
+		release := make(chan struct{})
+		hooks := PreStartHooks{
+			Parallelism: tc.parallelism,
+			Timeout:     5 * time.Second,
+			Func: func(ctx context.Context, id string) error {
+				lock.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				if most == tc.want {
+					select {
+					case <-release:
+					default:
+						close(release)
+					}
+				}
+				lock.Unlock()
+				defer func() {
+					lock.Lock()
+					running--
+					lock.Unlock()
+				}()
+				select {
+				case <-release:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		}
+		if err := hooks.PreStartFunc()([]string{"dev-0", "dev-1", "dev-2", "dev-3"}); err != nil {
+			t.Errorf("parallelism %d: %v", tc.parallelism, err)
+		}
+		if most != tc.want {
+			t.Errorf("parallelism %d: got %d devices initialized at once, want %d", tc.parallelism, most, tc.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// RateConfig limits how often devices are sent to kubelet, collapsing a
+// burst of updates into the latest devices.
+type RateConfig struct {
+	// A change is sent once devices stay unchanged for MinInterval, and no
+	// sooner than MinInterval after the last send.
+	MinInterval time.Duration
+	// MaxDelay is the longest a change waits for updates to settle,
+	// MinInterval by default.
+	MaxDelay time.Duration
+	// UrgentUnhealthy sends devices turning Unhealthy without delay.
+	UrgentUnhealthy bool
+}
+
+func (c *RateConfig) validate() error {
+	if c.MinInterval <= 0 {
+		return fmt.Errorf("update min interval shall be positive")
+	}
+	if c.MaxDelay != 0 && c.MaxDelay < c.MinInterval {
+		return fmt.Errorf("update max delay cannot be less than min interval")
+	}
+	return nil
+}
+
+// updateLimiter decides when to send devices on a ListAndWatch stream.
+// Devices same as last sent are never sent again.
+type updateLimiter struct {
+	conf *RateConfig
+
+	last    []*pluginapi.Device
+	sentAt  time.Time
+	pending []*pluginapi.Device
+	// firstChange and lastChange are when pending changes happened, zero
+	// if nothing is pending.
+	firstChange, lastChange time.Time
+	urgent                  bool
+}
+
+// update records devs at now if they differ from last sent, and returns
+// whether there is a change to send.
+func (l *updateLimiter) update(devs []*pluginapi.Device, now time.Time) bool {
+	l.pending = devs
+	if l.sentAt.IsZero() {
+		// First send of stream.
+		l.urgent = true
+		return true
+	}
+	if sameDevices(devs, l.last) {
+		l.firstChange, l.lastChange, l.urgent = time.Time{}, time.Time{}, false
+		return false
+	}
+
+	if l.firstChange.IsZero() {
+		l.firstChange = now
+	}
+	l.lastChange = now
+	if l.conf != nil && l.conf.UrgentUnhealthy && turnedUnhealthy(l.last, devs) {
+		l.urgent = true
+	}
+	return true
+}
+
+// delay returns how long a pending change waits before sent, zero if it
+// shall be sent now.
+func (l *updateLimiter) delay(now time.Time) time.Duration {
+	if l.conf == nil || l.urgent {
+		return 0
+	}
+	maxDelay := l.conf.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = l.conf.MinInterval
+	}
+
+	at := l.lastChange.Add(l.conf.MinInterval)
+	if deadline := l.firstChange.Add(maxDelay); at.After(deadline) {
+		at = deadline
+	}
+	if earliest := l.sentAt.Add(l.conf.MinInterval); at.Before(earliest) {
+		at = earliest
+	}
+	if d := at.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (l *updateLimiter) sent(devs []*pluginapi.Device, now time.Time) {
+	l.last, l.pending = devs, nil
+	l.sentAt = now
+	l.firstChange, l.lastChange, l.urgent = time.Time{}, time.Time{}, false
+}
+
+// sameDevices returns whether a and b are the same devices in the same
+// order. Devices shared with the cache are compared by pointer.
+func sameDevices(a, b []*pluginapi.Device) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if a[i].ID != b[i].ID || a[i].Health != b[i].Health || !reflect.DeepEqual(a[i].Topology, b[i].Topology) {
+			return false
+		}
+	}
+	return true
+}
+
+// turnedUnhealthy returns whether any device Healthy in last is not Healthy
+// in devs. Devices at the same position are compared without a lookup.
+func turnedUnhealthy(last, devs []*pluginapi.Device) bool {
+	var healthy map[string]bool
+	for i, d := range devs {
+		if d.Health == pluginapi.Healthy {
+			continue
+		}
+		if i < len(last) && last[i].ID == d.ID {
+			if last[i].Health == pluginapi.Healthy {
+				return true
+			}
+			continue
+		}
+		if healthy == nil {
+			healthy = make(map[string]bool, len(last))
+			for _, l := range last {
+				if l.Health == pluginapi.Healthy {
+					healthy[l.ID] = true
+				}
+			}
+		}
+		if healthy[d.ID] {
+			return true
+		}
+	}
+	return false
+}
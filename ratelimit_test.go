@@ -0,0 +1,151 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// limiterStep updates limiter with devices of health at, or sends pending
+// devices at if health is empty.
+type limiterStep struct {
+	at     time.Duration
+	health string
+	// want is what update returns, and delay is how long the change
+	// waits after update.
+	want  bool
+	delay time.Duration
+}
+
+// healthDevices returns devices dev-0, dev-1, ..., Healthy for each H in
+// health and Unhealthy otherwise.
+func healthDevices(health string) []*pluginapi.Device {
+	devs := make([]*pluginapi.Device, 0, len(health))
+	for i, h := range health {
+		d := &pluginapi.Device{ID: fmt.Sprintf("dev-%d", i), Health: pluginapi.Unhealthy}
+		if h == 'H' {
+			d.Health = pluginapi.Healthy
+		}
+		devs = append(devs, d)
+	}
+	return devs
+}
+
+func TestUpdateLimiter(t *testing.T) {
+	ms := time.Millisecond
+	debounce := &RateConfig{MinInterval: 100 * ms, MaxDelay: 300 * ms}
+	urgent := &RateConfig{MinInterval: 100 * ms, MaxDelay: 300 * ms, UrgentUnhealthy: true}
+
+	cases := []struct {
+		name  string
+		conf  *RateConfig
+		steps []limiterStep
+	}{
+		{
+			name: "first send at once",
+			conf: debounce,
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true, delay: 0},
+			},
+		},
+		{
+			name: "no limit",
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "UH", want: true, delay: 0},
+				{at: 10 * ms},
+				{at: 20 * ms, health: "UH", want: false},
+			},
+		},
+		{
+			name: "burst waits until settled",
+			conf: debounce,
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "UH", want: true, delay: 100 * ms},
+				{at: 50 * ms, health: "UU", want: true, delay: 100 * ms},
+				{at: 90 * ms, health: "UHH", want: true, delay: 100 * ms},
+				{at: 190 * ms},
+				{at: 200 * ms, health: "UHH", want: false},
+			},
+		},
+		{
+			name: "max delay caps burst",
+			conf: debounce,
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "UH", want: true, delay: 100 * ms},
+				{at: 100 * ms, health: "HH", want: false},
+				{at: 110 * ms, health: "HU", want: true, delay: 100 * ms},
+				{at: 200 * ms, health: "UH", want: true, delay: 100 * ms},
+				{at: 260 * ms, health: "HU", want: true, delay: 100 * ms},
+				{at: 350 * ms, health: "UH", want: true, delay: 60 * ms},
+				{at: 400 * ms, health: "HU", want: true, delay: 10 * ms},
+				{at: 420 * ms, health: "UH", want: true, delay: 0},
+			},
+		},
+		{
+			name: "revert to last sent is not sent",
+			conf: debounce,
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "UH", want: true, delay: 100 * ms},
+				{at: 20 * ms, health: "HH", want: false},
+				{at: 500 * ms, health: "HH", want: false},
+			},
+		},
+		{
+			name: "urgent unhealthy",
+			conf: urgent,
+			steps: []limiterStep{
+				{at: 0, health: "HH", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "HU", want: true, delay: 0},
+				{at: 10 * ms},
+				{at: 20 * ms, health: "HH", want: true, delay: 100 * ms},
+				{at: 30 * ms, health: "UH", want: true, delay: 0},
+				{at: 30 * ms},
+				{at: 40 * ms, health: "UHU", want: true, delay: 100 * ms},
+			},
+		},
+		{
+			name: "healthy devices are not urgent",
+			conf: urgent,
+			steps: []limiterStep{
+				{at: 0, health: "UU", want: true},
+				{at: 0},
+				{at: 10 * ms, health: "HU", want: true, delay: 100 * ms},
+				{at: 20 * ms, health: "U", want: true, delay: 100 * ms},
+			},
+		},
+	}
+
+	start := time.Now()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := &updateLimiter{conf: c.conf}
+			for i, s := range c.steps {
+				now := start.Add(s.at)
+				if s.health == "" {
+					l.sent(l.pending, now)
+					continue
+				}
+				if got := l.update(healthDevices(s.health), now); got != s.want {
+					t.Fatalf("step %d: update of %s returned %v, want %v", i, s.health, got, s.want)
+				}
+				if !s.want {
+					continue
+				}
+				if got := l.delay(now); got != s.delay {
+					t.Errorf("step %d: delay after %s is %v, want %v", i, s.health, got, s.delay)
+				}
+			}
+		})
+	}
+}
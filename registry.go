@@ -0,0 +1,172 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Registry is a mutable set of devices, for sources such as hotplug
+// handlers changing one device at a time. It's safe for concurrent use.
+// Changes made while devices are being sent are collapsed into the next
+// send, so a burst of changes costs one copy of the list.
+type Registry struct {
+	lock    sync.Mutex
+	devices []*Device
+	// index maps device ID to its position in devices.
+	index map[string]int
+	// watchers are notified of changes, one per Devices call.
+	watchers map[chan struct{}]struct{}
+}
+
+// NewRegistry returns a registry of devs.
+func NewRegistry(devs ...*Device) *Registry {
+	r := &Registry{index: map[string]int{}, watchers: map[chan struct{}]struct{}{}}
+	r.Add(devs...)
+	return r
+}
+
+// Add adds devs, or updates devices with the same IDs. Devices are copied,
+// so callers may reuse them.
+func (r *Registry) Add(devs ...*Device) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.addLocked(devs) {
+		r.notifyLocked()
+	}
+}
+
+// addLocked adds devs, and returns whether any is added or changed.
+func (r *Registry) addLocked(devs []*Device) bool {
+	changed := false
+	for _, d := range devs {
+		copied := *d
+		if i, ok := r.index[d.ID]; !ok {
+			r.index[d.ID] = len(r.devices)
+			r.devices = append(r.devices, &copied)
+		} else if !reflect.DeepEqual(r.devices[i], &copied) {
+			r.devices[i] = &copied
+		} else {
+			continue
+		}
+		changed = true
+	}
+	return changed
+}
+
+// Remove removes devices with ids. Unknown ids are ignored.
+func (r *Registry) Remove(ids ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	removed := false
+	for _, id := range ids {
+		i, ok := r.index[id]
+		if !ok {
+			continue
+		}
+		// Move the last device into the hole, so that removal doesn't shift
+		// thousands of devices.
+		last := len(r.devices) - 1
+		r.devices[i] = r.devices[last]
+		r.index[r.devices[i].ID] = i
+		r.devices[last] = nil
+		r.devices = r.devices[:last]
+		delete(r.index, id)
+		removed = true
+	}
+	if removed {
+		r.notifyLocked()
+	}
+}
+
+// SetHealth sets health of device with id.
+func (r *Registry) SetHealth(id, health string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i, ok := r.index[id]
+	if !ok {
+		return fmt.Errorf("unknown device %s", id)
+	}
+	if r.devices[i].Health == health {
+		return nil
+	}
+	// Devices sent are not changed, so copy it.
+	updated := *r.devices[i]
+	updated.Health = health
+	r.devices[i] = &updated
+	r.notifyLocked()
+	return nil
+}
+
+// Replace replaces all devices with devs.
+func (r *Registry) Replace(devs []*Device) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.devices = make([]*Device, 0, len(devs))
+	r.index = make(map[string]int, len(devs))
+	r.addLocked(devs)
+	r.notifyLocked()
+}
+
+// Get returns device with id.
+func (r *Registry) Get(id string) (*Device, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if i, ok := r.index[id]; ok {
+		return r.devices[i], true
+	}
+	return nil, false
+}
+
+// List returns all devices, which shall not be changed.
+func (r *Registry) List() []*Device {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*Device(nil), r.devices...)
+}
+
+// Len returns number of devices.
+func (r *Registry) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.devices)
+}
+
+// Devices sends devices, and again whenever they change, until stop is
+// closed. Set it to Config.Devices. Each call gets every change, so that
+// several plugins or watchers can share a registry.
+func (r *Registry) Devices(stop <-chan struct{}) <-chan []*Device {
+	ch := make(chan []*Device)
+	changed := make(chan struct{}, 1)
+	r.lock.Lock()
+	r.watchers[changed] = struct{}{}
+	r.lock.Unlock()
+
+	go func() {
+		defer func() {
+			r.lock.Lock()
+			delete(r.watchers, changed)
+			r.lock.Unlock()
+		}()
+		for {
+			if !sendDevices(ch, r.List(), stop) {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-changed:
+			}
+		}
+	}()
+	return ch
+}
+
+func (r *Registry) notifyLocked() {
+	for w := range r.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package deviceplugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func registryIDs(r *Registry) string {
+	var ids []string
+	for _, d := range r.List() {
+		ids = append(ids, d.ID+":"+d.Health)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestRegistry(t *testing.T) {
+	dev := func(id string) *Device { return &Device{ID: id, Health: pluginapi.Healthy} }
+	r := NewRegistry(dev("a"), dev("b"), dev("c"), dev("d"))
+	changed := make(chan struct{}, 1)
+	r.watchers[changed] = struct{}{}
+
+	for _, step := range []struct {
+		name   string
+		change func() error
+		// want is devices in order, and notified whether watchers are.
+		want     string
+		notified bool
+	}{
+		{
+			name:     "remove swaps last into hole",
+			change:   func() error { r.Remove("b"); return nil },
+			want:     "a:Healthy d:Healthy c:Healthy",
+			notified: true,
+		},
+		{
+			name:   "remove unknown",
+			change: func() error { r.Remove("x"); return nil },
+			want:   "a:Healthy d:Healthy c:Healthy",
+		},
+		{
+			name:     "remove last",
+			change:   func() error { r.Remove("c"); return nil },
+			want:     "a:Healthy d:Healthy",
+			notified: true,
+		},
+		{
+			name:     "add appends",
+			change:   func() error { r.Add(dev("e"), dev("b")); return nil },
+			want:     "a:Healthy d:Healthy e:Healthy b:Healthy",
+			notified: true,
+		},
+		{
+			name:   "add unchanged",
+			change: func() error { r.Add(dev("a")); return nil },
+			want:   "a:Healthy d:Healthy e:Healthy b:Healthy",
+		},
+		{
+			name: "add updates in place",
+			change: func() error {
+				r.Add(&Device{ID: "d", Health: pluginapi.Healthy, Attrs: map[string]string{"fw": "2"}})
+				return nil
+			},
+			want:     "a:Healthy d:Healthy e:Healthy b:Healthy",
+			notified: true,
+		},
+		{
+			name:     "set health",
+			change:   func() error { return r.SetHealth("e", pluginapi.Unhealthy) },
+			want:     "a:Healthy d:Healthy e:Unhealthy b:Healthy",
+			notified: true,
+		},
+		{
+			name:   "set health unchanged",
+			change: func() error { return r.SetHealth("e", pluginapi.Unhealthy) },
+			want:   "a:Healthy d:Healthy e:Unhealthy b:Healthy",
+		},
+		{
+			name:     "replace keeps order given",
+			change:   func() error { r.Replace([]*Device{dev("z"), dev("a")}); return nil },
+			want:     "z:Healthy a:Healthy",
+			notified: true,
+		},
+	} {
+		t.Run(step.name, func(t *testing.T) {
+			if err := step.change(); err != nil {
+				t.Fatal(err)
+			}
+			if got := registryIDs(r); got != step.want {
+				t.Errorf("got devices %s, want %s", got, step.want)
+			}
+			notified := false
+			select {
+			case <-changed:
+				notified = true
+			default:
+			}
+			if notified != step.notified {
+				t.Errorf("notified is %v, want %v", notified, step.notified)
+			}
+			for i, d := range r.List() {
+				if r.index[d.ID] != i {
+					t.Errorf("index of %s is %d, want %d", d.ID, r.index[d.ID], i)
+				}
+			}
+		})
+	}
+
+	if err := r.SetHealth("x", pluginapi.Unhealthy); err == nil {
+		t.Error("health of unknown device is set")
+	}
+	if d, ok := r.Get("a"); !ok || d.ID != "a" {
+		t.Errorf("got %v, %v", d, ok)
+	}
+	if r.Len() != 2 {
+		t.Errorf("got length %d, want 2", r.Len())
+	}
+}
+
+func TestRegistryCopies(t *testing.T) {
+	d := &Device{ID: "a", Health: pluginapi.Healthy}
+	r := NewRegistry(d)
+	sent := r.List()
+	d.Health = pluginapi.Unhealthy
+	if err := r.SetHealth("a", pluginapi.Unhealthy); err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].Health != pluginapi.Healthy {
+		t.Error("device sent is changed")
+	}
+}
+
+func TestRegistryDevicesConsumers(t *testing.T) {
+	r := NewRegistry(&Device{ID: "a", Health: pluginapi.Healthy})
+	stop := make(chan struct{})
+	defer close(stop)
+	first, second := r.Devices(stop), r.Devices(stop)
+	receive := func(ch <-chan []*Device, want int) {
+		select {
+		case devs := <-ch:
+			if len(devs) != want {
+				t.Errorf("got %d devices, want %d", len(devs), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no devices sent")
+		}
+	}
+	receive(first, 1)
+	receive(second, 1)
+
+	r.Add(&Device{ID: "b", Health: pluginapi.Healthy})
+	receive(first, 2)
+	receive(second, 2)
+
+	// Consumer stopped is no longer notified.
+	stopOne := make(chan struct{})
+	r.Devices(stopOne)
+	close(stopOne)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		r.lock.Lock()
+		watchers := len(r.watchers)
+		r.lock.Unlock()
+		if watchers == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d watchers after stop, want 2", watchers)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReleaseFunc cleans up a device after the container using it is gone,
+// such as resetting, scrubbing or reloading its firmware.
+type ReleaseFunc func(id string) error
+
+// releaseGrace is how long a device allocated is regarded in use before
+// it's found assigned, since kubelet may not have created the container yet.
+const releaseGrace = time.Minute
+
+// allocateReleaseWait is how long Allocate waits for devices to be released,
+// well before kubelet gives up on it.
+const allocateReleaseWait = 10 * time.Second
+
+// releaser releases devices no longer assigned to any container, as found
+// by the owner index.
+type releaser struct {
+	resourceName string
+	release      ReleaseFunc
+	grace        time.Duration
+	allocateWait time.Duration
+	// notify is called when devices being released change.
+	notify func()
+
+	// releaseLock serializes starting releases, and tracking allocations
+	// of devices. ReleaseFunc runs without it, so that a slow one never
+	// blocks allocations of other devices.
+	releaseLock sync.Mutex
+	// tracked are devices allocated and not released yet, with the time
+	// they are allocated or found assigned.
+	tracked *idSet
+
+	lock sync.Mutex
+	// seen are tracked devices found assigned, with their last owners.
+	seen map[string]Owner
+	// releasing are devices found free, Unhealthy until released.
+	releasing map[string]bool
+	// inFlight are releases running.
+	inFlight map[string]*release
+}
+
+// release is a run of ReleaseFunc, whose err is set when done is closed.
+type release struct {
+	done chan struct{}
+	err  error
+}
+
+func newReleaser(conf Config, notify func()) *releaser {
+	r := &releaser{
+		resourceName: conf.ResourceName,
+		release:      conf.ReleaseFunc,
+		grace:        releaseGrace,
+		allocateWait: allocateReleaseWait,
+		notify:       notify,
+		tracked:      loadIDSet(stateFile(conf.StateDir, conf.ResourceName, "allocated.json")),
+		seen:         map[string]Owner{},
+		releasing:    map[string]bool{},
+		inFlight:     map[string]*release{},
+	}
+	return r
+}
+
+// Releasing returns whether device with id is free but not released yet.
+func (r *releaser) Releasing(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.releasing[id]
+}
+
+// releasingIDs returns devices being released.
+func (r *releaser) releasingIDs() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ids := make([]string, 0, len(r.releasing))
+	for id := range r.releasing {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// releaseAllocated releases ids allocated before and not released yet,
+// since kubelet allocates only devices no longer used. Call it before ids
+// are set up for a new container. It fails with Unavailable if releases
+// take longer than allocateWait, so that kubelet retries later.
+func (r *releaser) releaseAllocated(ids []string) error {
+	r.releaseLock.Lock()
+	var started []*release
+	for _, id := range ids {
+		if _, ok := r.tracked.Get(id); ok {
+			started = append(started, r.startLocked(id))
+		}
+	}
+	r.releaseLock.Unlock()
+
+	timeout := time.NewTimer(r.allocateWait)
+	defer timeout.Stop()
+	for _, rel := range started {
+		select {
+		case <-rel.done:
+			if rel.err != nil {
+				return rel.err
+			}
+		case <-timeout.C:
+			return status.Errorf(codes.Unavailable, "devices %v are still being released", ids)
+		}
+	}
+	return nil
+}
+
+// allocated tracks ids allocated, to release them once they are free.
+func (r *releaser) allocated(ids []string) error {
+	r.releaseLock.Lock()
+	defer r.releaseLock.Unlock()
+	// Owners seen before are of the last container, which may be found
+	// again while ids are being allocated.
+	r.lock.Lock()
+	for _, id := range ids {
+		delete(r.seen, id)
+	}
+	r.lock.Unlock()
+
+	now := time.Now()
+	return r.tracked.Update(func(tracked map[string]time.Time) bool {
+		for _, id := range ids {
+			tracked[id] = now
+		}
+		return true
+	})
+}
+
+// poll releases tracked devices not in owners. A device is regarded in use
+// if it has not been seen assigned, and was allocated within grace.
+func (r *releaser) poll(owners map[string]Owner) {
+	now := time.Now()
+	free := map[string]time.Time{}
+	err := r.tracked.Update(func(tracked map[string]time.Time) bool {
+		changed := false
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		for id, o := range owners {
+			if _, ok := tracked[id]; !ok {
+				// Allocated before plugin starts.
+				tracked[id] = now
+				changed = true
+			}
+			r.seen[id] = o
+		}
+		for id, t := range tracked {
+			_, seen := r.seen[id]
+			if _, ok := owners[id]; !ok && (seen || now.Sub(t) > r.grace) {
+				free[id] = t
+				r.releasing[id] = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		log.Printf("Could not track devices of %s to release: %v", r.resourceName, err)
+		return
+	}
+	if len(free) == 0 {
+		return
+	}
+	r.notify()
+
+	ids := make([]string, 0, len(free))
+	for id := range free {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var rel *release
+		r.releaseLock.Lock()
+		// Skip device allocated again since found free, which is released
+		// on allocation.
+		if t, ok := r.tracked.Get(id); ok && t.Equal(free[id]) {
+			rel = r.startLocked(id)
+		}
+		r.releaseLock.Unlock()
+		if rel == nil {
+			continue
+		}
+		<-rel.done
+		if rel.err != nil {
+			log.Printf("Could not release device %s of %s: %v", id, r.resourceName, rel.err)
+		}
+	}
+}
+
+// startLocked starts releasing id, or returns the release of id running.
+func (r *releaser) startLocked(id string) *release {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if rel, ok := r.inFlight[id]; ok {
+		return rel
+	}
+	rel := &release{done: make(chan struct{})}
+	r.inFlight[id] = rel
+	go func() {
+		rel.err = r.releaseOne(id)
+		r.lock.Lock()
+		delete(r.inFlight, id)
+		r.lock.Unlock()
+		close(rel.done)
+	}()
+	return rel
+}
+
+// releaseOne runs ReleaseFunc of id, and stops tracking it if succeeded.
+// Device stays Unhealthy until it succeeds.
+func (r *releaser) releaseOne(id string) error {
+	err := r.release(id)
+	if err == nil {
+		_, err = r.tracked.Set(id, false)
+	}
+
+	r.lock.Lock()
+	wasReleasing := r.releasing[id]
+	owner, seen := r.seen[id]
+	if err == nil {
+		delete(r.seen, id)
+		delete(r.releasing, id)
+	} else {
+		r.releasing[id] = true
+	}
+	r.lock.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("release of %s failed: %v", id, err)
+	} else if seen {
+		log.Printf("Released device %s of %s, last used by %s", id, r.resourceName, owner)
+	} else {
+		log.Printf("Released device %s of %s", id, r.resourceName)
+	}
+	if wasReleasing != (err != nil) {
+		r.notify()
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// TestReleaseBeforeAllocate checks a device allocated again before it's
+// found free is released before it's set up for the new container.
+func TestReleaseBeforeAllocate(t *testing.T) {
+	var calls []string
+	releaseErr := error(nil)
+	conf := Config{
+		ResourceName: "example.com/dev",
+		AllocateFunc: func(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+			calls = append(calls, "allocate "+ids[0])
+			return &pluginapi.ContainerAllocateResponse{}, nil
+		},
+		ReleaseFunc: func(id string) error {
+			calls = append(calls, "release "+id)
+			return releaseErr
+		},
+	}
+	p := newDevicePlugin(conf, nil)
+	p.state.cache.Set([]*Device{{ID: "dev-0", Health: pluginapi.Healthy}})
+	allocate := func() error {
+		_, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"dev-0"}}},
+		})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"allocate dev-0", "release dev-0", "allocate dev-0"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+
+	calls = nil
+	releaseErr = fmt.Errorf("busy")
+	if err := allocate(); err == nil {
+		t.Error("Allocate succeeded, but release failed")
+	}
+	want = []string{"release dev-0"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+}
+
+// TestReleaseSlow checks a slow release fails Allocate of its device fast,
+// without blocking allocations of other devices.
+func TestReleaseSlow(t *testing.T) {
+	unblock := make(chan struct{})
+	var lock sync.Mutex
+	releases := map[string]int{}
+	conf := Config{
+		ResourceName: "example.com/dev",
+		ReleaseFunc: func(id string) error {
+			lock.Lock()
+			releases[id]++
+			lock.Unlock()
+			if id == "dev-0" {
+				<-unblock
+			}
+			return nil
+		},
+	}
+	p := newDevicePlugin(conf, nil)
+	p.state.releaser.allocateWait = 50 * time.Millisecond
+	p.state.cache.Set([]*Device{{ID: "dev-0", Health: pluginapi.Healthy}, {ID: "dev-1", Health: pluginapi.Healthy}})
+	allocate := func(id string) error {
+		_, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{id}}},
+		})
+		return err
+	}
+
+	if err := allocate("dev-0"); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if err := allocate("dev-0"); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v while releasing, want code %v", err, codes.Unavailable)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("Allocate took %v while releasing", time.Since(started))
+	}
+	for i := 0; i < 2; i++ {
+		if err := allocate("dev-1"); err != nil {
+			t.Errorf("Allocate of another device failed while releasing: %v", err)
+		}
+	}
+
+	// Allocate again joins the release in flight.
+	done := make(chan error, 1)
+	go func() { done <- allocate("dev-0") }()
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Errorf("Allocate failed after release: %v", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if releases["dev-0"] != 1 || releases["dev-1"] != 1 {
+		t.Errorf("got releases %v, want one of each", releases)
+	}
+}
@@ -0,0 +1,419 @@
+package deviceplugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// RemoteConfig configures forwarding of Allocate and PreStart to a device
+// management daemon.
+type RemoteConfig struct {
+	// Endpoint of the daemon:
+	//	http://host:port/prefix   HTTP/JSON, see RemoteHandler
+	//	unix:///path/to/socket    HTTP/JSON over unix socket
+	//	grpc://host:port          gRPC DevicePlugin service
+	//	grpc+unix:///path/to/sock gRPC DevicePlugin service over unix socket
+	Endpoint string
+	// ResourceName is sent along with device IDs.
+	ResourceName string
+
+	// Timeout of each attempt, 5s by default.
+	Timeout time.Duration
+	// Retries of attempts failed with Unavailable or DeadlineExceeded,
+	// waiting RetryBackoff, doubled after each retry.
+	Retries      int
+	RetryBackoff time.Duration
+	// TotalTimeout bounds attempts and backoff of a call, 25s by default,
+	// since kubelet waits 30s for PreStartContainer. No retry is made if
+	// it would not finish in time.
+	TotalTimeout time.Duration
+	// The circuit opens after FailureThreshold calls failed in a row with
+	// Unavailable or DeadlineExceeded, failing calls at once for
+	// OpenDuration, after which one call is let through to try again.
+	// It's 5 and 30s by default, and never opens if FailureThreshold < 0.
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// Remote forwards Allocate and PreStart to a device management daemon. Use
+// its methods as AllocateFunc and PreStartFunc.
+type Remote struct {
+	conf    RemoteConfig
+	breaker *breaker
+
+	// Only one of them is set.
+	http    *http.Client
+	baseURL string
+	grpc    pluginapi.DevicePluginClient
+	conn    *grpc.ClientConn
+}
+
+type remoteRequest struct {
+	ResourceName string   `json:"resourceName,omitempty"`
+	DevicesIDs   []string `json:"devicesIDs"`
+}
+
+// NewRemote returns Remote for conf. Connection is made at first call.
+func NewRemote(conf RemoteConfig) (*Remote, error) {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	if conf.RetryBackoff <= 0 {
+		conf.RetryBackoff = 100 * time.Millisecond
+	}
+	if conf.TotalTimeout <= 0 {
+		conf.TotalTimeout = 25 * time.Second
+	}
+	if conf.FailureThreshold == 0 {
+		conf.FailureThreshold = 5
+	}
+	if conf.OpenDuration <= 0 {
+		conf.OpenDuration = 30 * time.Second
+	}
+
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %v", conf.Endpoint, err)
+	}
+	r := &Remote{conf: conf, breaker: &breaker{threshold: conf.FailureThreshold, open: conf.OpenDuration}}
+
+	switch u.Scheme {
+	case "http", "https":
+		r.http = &http.Client{}
+		r.baseURL = strings.TrimSuffix(conf.Endpoint, "/")
+	case "unix":
+		r.http = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", u.Path)
+			},
+		}}
+		r.baseURL = "http://unix"
+	case "grpc", "grpc+unix":
+		target, network := u.Host, "tcp"
+		if u.Scheme == "grpc+unix" {
+			target, network = u.Path, "unix"
+		}
+		r.conn, err = grpc.Dial(target, grpc.WithInsecure(),
+			grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+				return net.DialTimeout(network, addr, timeout)
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.grpc = pluginapi.NewDevicePluginClient(r.conn)
+	default:
+		return nil, fmt.Errorf("unsupported endpoint %s", conf.Endpoint)
+	}
+	return r, nil
+}
+
+// Close closes connection to the daemon.
+func (r *Remote) Close() error {
+	if r.conn != nil {
+		return r.conn.Close()
+	}
+	return nil
+}
+
+// Allocate forwards allocation of ids.
+func (r *Remote) Allocate(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	var resp *pluginapi.ContainerAllocateResponse
+	err := r.call(func(ctx context.Context) error {
+		var err error
+		if r.grpc != nil {
+			resp, err = r.allocateGRPC(ctx, ids)
+		} else {
+			resp = &pluginapi.ContainerAllocateResponse{}
+			err = r.post(ctx, "/allocate", ids, resp)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// PreStart forwards PreStartContainer of ids.
+func (r *Remote) PreStart(ids []string) error {
+	return r.call(func(ctx context.Context) error {
+		if r.grpc != nil {
+			_, err := r.grpc.PreStartContainer(r.outgoing(ctx), &pluginapi.PreStartContainerRequest{DevicesIDs: ids})
+			return err
+		}
+		return r.post(ctx, "/prestart", ids, nil)
+	})
+}
+
+// call runs attempt with timeout, retries and circuit breaker.
+func (r *Remote) call(attempt func(context.Context) error) error {
+	if !r.breaker.allow() {
+		return status.Errorf(codes.Unavailable, "circuit to %s is open after %d failures", r.conf.Endpoint, r.conf.FailureThreshold)
+	}
+
+	deadline := time.Now().Add(r.conf.TotalTimeout)
+	total, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	backoff := r.conf.RetryBackoff
+	var err error
+	for i := 0; ; i++ {
+		ctx, cancel := context.WithTimeout(total, r.conf.Timeout)
+		err = attempt(ctx)
+		// Attempt succeeding right at the deadline is not retried, which
+		// would allocate twice.
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = status.Errorf(codes.DeadlineExceeded, "%s did not respond in time, attempt %d: %v", r.conf.Endpoint, i+1, err)
+		}
+		cancel()
+		if !retryable(err) || i >= r.conf.Retries {
+			break
+		}
+		// Next attempt shall have time to respond after backoff.
+		if time.Until(deadline) <= backoff {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	r.breaker.done(retryable(err))
+	return err
+}
+
+func (r *Remote) allocateGRPC(ctx context.Context, ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	resp, err := r.grpc.Allocate(r.outgoing(ctx), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.ContainerResponses) != 1 {
+		return nil, status.Errorf(codes.Internal, "%s returned %d responses for 1 container", r.conf.Endpoint, len(resp.ContainerResponses))
+	}
+	return resp.ContainerResponses[0], nil
+}
+
+func (r *Remote) outgoing(ctx context.Context) context.Context {
+	if r.conf.ResourceName == "" {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, metadata.Pairs("resource-name", r.conf.ResourceName))
+}
+
+// post posts ids to path, and decodes the response into out if not nil.
+func (r *Remote) post(ctx context.Context, path string, ids []string, out interface{}) error {
+	body, _ := json.Marshal(remoteRequest{ResourceName: r.conf.ResourceName, DevicesIDs: ids})
+	req, err := http.NewRequest(http.MethodPost, r.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "could not reach %s: %v", r.conf.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "could not read response of %s: %v", r.conf.Endpoint, err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var reported errorBody
+		if json.Unmarshal(data, &reported) == nil && reported.Code != codes.OK {
+			return status.Error(reported.Code, reported.Message)
+		}
+		return status.Errorf(httpCode(resp.StatusCode), "%s%s returned %s: %s", r.conf.Endpoint, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		if err = json.Unmarshal(data, out); err != nil {
+			return status.Errorf(codes.Internal, "%s%s returned invalid response: %v", r.conf.Endpoint, path, err)
+		}
+	}
+	return nil
+}
+
+func httpCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Unknown
+	}
+}
+
+func retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	s, _ := status.FromError(err)
+	return s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded
+}
+
+// breaker is a circuit breaker counting failures in a row.
+type breaker struct {
+	lock      sync.Mutex
+	threshold int
+	open      time.Duration
+
+	failures int
+	openedAt time.Time
+	// trying is set while the one call after open duration is in flight.
+	trying bool
+}
+
+func (b *breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.threshold < 0 || b.failures < b.threshold {
+		return true
+	}
+	if b.trying || time.Since(b.openedAt) < b.open {
+		return false
+	}
+	b.trying = true
+	return true
+}
+
+func (b *breaker) done(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trying = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// RemoteHandler serves the HTTP/JSON schema Remote calls, for daemons
+// written in Go or standing in for tests. It serves POST /allocate and
+// /prestart with body {"resourceName": "...", "devicesIDs": [...]}.
+// /allocate responds with a ContainerAllocateResponse as JSON. Errors are
+// responded with status 500, or 503 if their code is Unavailable, and
+// body {"code": <gRPC code>, "message": "..."}.
+func RemoteHandler(allocate AllocateFunc, preStart PreStartFunc) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, f func([]string) (interface{}, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var r remoteRequest
+			if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorBody{Code: codes.InvalidArgument, Message: err.Error()})
+				return
+			}
+
+			resp, err := f(r.DevicesIDs)
+			if err != nil {
+				s, _ := status.FromError(err)
+				code := http.StatusInternalServerError
+				if s.Code() == codes.Unavailable {
+					code = http.StatusServiceUnavailable
+				}
+				writeJSON(w, code, errorBody{Code: s.Code(), Message: s.Message()})
+				return
+			}
+			writeJSON(w, http.StatusOK, resp)
+		})
+	}
+
+	handle("/allocate", func(ids []string) (interface{}, error) {
+		if allocate == nil {
+			return &pluginapi.ContainerAllocateResponse{}, nil
+		}
+		return allocate(ids)
+	})
+	handle("/prestart", func(ids []string) (interface{}, error) {
+		if preStart == nil {
+			return struct{}{}, nil
+		}
+		return struct{}{}, preStart(ids)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RemoteServer serves the gRPC schema Remote calls, which is the
+// DevicePlugin service with only Allocate and PreStartContainer
+// implemented, for daemons written in Go or standing in for tests.
+func RemoteServer(allocate AllocateFunc, preStart PreStartFunc) pluginapi.DevicePluginServer {
+	return &remoteServer{allocate: allocate, preStart: preStart}
+}
+
+type remoteServer struct {
+	allocate AllocateFunc
+	preStart PreStartFunc
+}
+
+func (s *remoteServer) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{PreStartRequired: s.preStart != nil}, nil
+}
+
+func (s *remoteServer) ListAndWatch(*pluginapi.Empty, pluginapi.DevicePlugin_ListAndWatchServer) error {
+	return status.Error(codes.Unimplemented, "ListAndWatch is not served by remote")
+}
+
+func (s *remoteServer) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "GetPreferredAllocation is not served by remote")
+}
+
+func (s *remoteServer) Allocate(_ context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	resp := &pluginapi.AllocateResponse{}
+	for _, creq := range r.ContainerRequests {
+		cresp := &pluginapi.ContainerAllocateResponse{}
+		if s.allocate != nil {
+			var err error
+			if cresp, err = s.allocate(creq.DevicesIDs); err != nil {
+				return nil, err
+			}
+		}
+		resp.ContainerResponses = append(resp.ContainerResponses, cresp)
+	}
+	return resp, nil
+}
+
+func (s *remoteServer) PreStartContainer(_ context.Context, r *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	if s.preStart != nil {
+		if err := s.preStart(r.DevicesIDs); err != nil {
+			return nil, err
+		}
+	}
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
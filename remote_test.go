@@ -0,0 +1,161 @@
+package deviceplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRemoteCall(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		conf    RemoteConfig
+		attempt func(ctx context.Context, n int) error
+		code    codes.Code
+		// attempts are made, and the call takes less than within.
+		attempts int
+		within   time.Duration
+	}{
+		{
+			name:     "succeeded",
+			conf:     RemoteConfig{Retries: 2},
+			attempt:  func(context.Context, int) error { return nil },
+			attempts: 1,
+		},
+		{
+			name: "succeeded at deadline",
+			conf: RemoteConfig{Timeout: 50 * time.Millisecond, Retries: 2},
+			attempt: func(ctx context.Context, _ int) error {
+				<-ctx.Done()
+				return nil
+			},
+			attempts: 1,
+		},
+		{
+			name: "retried",
+			conf: RemoteConfig{Retries: 3, RetryBackoff: time.Millisecond},
+			attempt: func(_ context.Context, n int) error {
+				if n < 3 {
+					return status.Error(codes.Unavailable, "busy")
+				}
+				return nil
+			},
+			attempts: 3,
+		},
+		{
+			name: "retries exhausted",
+			conf: RemoteConfig{Retries: 2, RetryBackoff: time.Millisecond},
+			attempt: func(context.Context, int) error {
+				return status.Error(codes.Unavailable, "busy")
+			},
+			code:     codes.Unavailable,
+			attempts: 3,
+		},
+		{
+			name: "not retryable",
+			conf: RemoteConfig{Retries: 2},
+			attempt: func(context.Context, int) error {
+				return status.Error(codes.ResourceExhausted, "all busy")
+			},
+			code:     codes.ResourceExhausted,
+			attempts: 1,
+		},
+		{
+			name: "timed out",
+			conf: RemoteConfig{Timeout: 20 * time.Millisecond, Retries: 1, RetryBackoff: time.Millisecond},
+			attempt: func(ctx context.Context, _ int) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			code:     codes.DeadlineExceeded,
+			attempts: 2,
+		},
+		{
+			// Attempts and backoff are bounded by total timeout.
+			name: "total timeout",
+			conf: RemoteConfig{Timeout: 100 * time.Millisecond, Retries: 100, RetryBackoff: 50 * time.Millisecond, TotalTimeout: 300 * time.Millisecond},
+			attempt: func(ctx context.Context, _ int) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			code:     codes.DeadlineExceeded,
+			attempts: 2,
+			within:   400 * time.Millisecond,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.conf.Endpoint = "http://127.0.0.1:1"
+			tc.conf.FailureThreshold = -1
+			r, err := NewRemote(tc.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attempts := 0
+			started := time.Now()
+			err = r.call(func(ctx context.Context) error {
+				attempts++
+				return tc.attempt(ctx, attempts)
+			})
+			if code := grpc.Code(err); code != tc.code {
+				t.Errorf("got code %v of error %v, want %v", code, err, tc.code)
+			}
+			if attempts != tc.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, tc.attempts)
+			}
+			if tc.within > 0 && time.Since(started) > tc.within {
+				t.Errorf("call took %v, want within %v", time.Since(started), tc.within)
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 2, open: 50 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d is not allowed before threshold", i)
+		}
+		b.done(true)
+	}
+	if b.allow() {
+		t.Fatal("call is allowed while open")
+	}
+
+	// One call is let through after open duration, and a failure opens it
+	// again.
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("call is not allowed after open duration")
+	}
+	if b.allow() {
+		t.Fatal("second call is allowed while trying")
+	}
+	b.done(true)
+	if b.allow() {
+		t.Fatal("call is allowed after trying failed")
+	}
+
+	// A success closes it.
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("call is not allowed after open duration")
+	}
+	b.done(false)
+	for i := 0; i < 3; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d is not allowed after success", i)
+		}
+	}
+
+	never := &breaker{threshold: -1, open: time.Hour}
+	for i := 0; i < 10; i++ {
+		never.done(true)
+	}
+	if !never.allow() {
+		t.Error("breaker with negative threshold opens")
+	}
+}
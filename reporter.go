@@ -0,0 +1,256 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// NodeClient writes to the Kubernetes API, such as a few lines wrapping a
+// client-go clientset.
+type NodeClient interface {
+	CreateEvent(event *v1.Event) error
+	// SetNodeCondition sets condition in status of node, replacing the one
+	// of the same type.
+	SetNodeCondition(nodeName string, condition v1.NodeCondition) error
+}
+
+// ReporterConfig reports health transitions of devices as Events of the
+// Node, and optionally a Node condition.
+type ReporterConfig struct {
+	Client NodeClient
+	// NodeName is the node plugin runs on, NODE_NAME env by default.
+	NodeName string
+	// Namespace of events, default by default.
+	Namespace string
+	// ConditionType, if set, is a Node condition which is True if all
+	// devices are healthy, and False otherwise. Partitions set it suffixed
+	// by their resource names.
+	ConditionType v1.NodeConditionType
+}
+
+const (
+	reporterComponent = "device-plugin"
+	maxPendingEvents  = 256
+	// conditionRetryInterval is how often a condition failed to set is
+	// retried.
+	conditionRetryInterval = 30 * time.Second
+	// maxConditionDevices is how many unhealthy devices a condition message
+	// names.
+	maxConditionDevices = 10
+)
+
+// nodeNameOrEnv returns name, or NODE_NAME env if it's empty.
+func nodeNameOrEnv(name string) string {
+	if name == "" {
+		return os.Getenv("NODE_NAME")
+	}
+	return name
+}
+
+func (c *ReporterConfig) validate() error {
+	if c.Client == nil {
+		return fmt.Errorf("reporter client cannot be empty")
+	}
+	if nodeNameOrEnv(c.NodeName) == "" {
+		return fmt.Errorf("reporter node name cannot be empty, set it or NODE_NAME env")
+	}
+	return nil
+}
+
+type healthTransition struct {
+	ID string
+	// From is empty if device is new.
+	From, To string
+}
+
+// reporter sends events and condition in background, so that a slow API
+// server never blocks device updates.
+type reporter struct {
+	resourceName string
+	client       NodeClient
+	nodeName     string
+	namespace    string
+	condition    v1.NodeConditionType
+
+	events  chan *v1.Event
+	changed chan struct{}
+
+	lock sync.Mutex
+	// pending is the condition not set yet.
+	pending *v1.NodeCondition
+	// status is the condition status last set.
+	status          v1.ConditionStatus
+	statusChangedAt time.Time
+	// lastKey is number of devices and unhealthy ones of the condition
+	// last made, which its message tells.
+	lastKey string
+}
+
+func newReporter(resourceName string, conf *ReporterConfig) *reporter {
+	r := &reporter{
+		resourceName: resourceName,
+		client:       conf.Client,
+		nodeName:     nodeNameOrEnv(conf.NodeName),
+		namespace:    conf.Namespace,
+		condition:    conf.ConditionType,
+		events:       make(chan *v1.Event, maxPendingEvents),
+		changed:      make(chan struct{}, 1),
+	}
+	if r.namespace == "" {
+		r.namespace = metav1.NamespaceDefault
+	}
+	return r
+}
+
+// report queues events of transitions, and condition of devs if it
+// changed. Devices appearing Healthy are not reported.
+func (r *reporter) report(transitions []healthTransition, devs []*Device) {
+	for _, t := range transitions {
+		if t.From == "" && t.To == pluginapi.Healthy {
+			continue
+		}
+		select {
+		case r.events <- r.event(t):
+		default:
+			log.Printf("Dropped event of device %s of %s, too many pending", t.ID, r.resourceName)
+		}
+	}
+
+	if r.condition == "" {
+		return
+	}
+	var unhealthy []string
+	for _, d := range devs {
+		if d.Health != pluginapi.Healthy {
+			unhealthy = append(unhealthy, d.ID)
+		}
+	}
+	sort.Strings(unhealthy)
+	key := fmt.Sprintf("%d:%s", len(devs), strings.Join(unhealthy, ","))
+
+	r.lock.Lock()
+	if r.pending == nil && key == r.lastKey && !r.statusChangedAt.IsZero() {
+		r.lock.Unlock()
+		return
+	}
+	r.lastKey = key
+	r.pending = r.conditionOf(unhealthy, len(devs))
+	r.lock.Unlock()
+
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (r *reporter) event(t healthTransition) *v1.Event {
+	now := metav1.Now()
+	e := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", r.nodeName, now.UnixNano()),
+			Namespace: r.namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Node",
+			Name: r.nodeName,
+			// Kubelet uses node name as UID in events of node.
+			UID: types.UID(r.nodeName),
+		},
+		Reason:              "Device" + t.To,
+		Message:             fmt.Sprintf("Device %s of %s is %s", t.ID, r.resourceName, t.To),
+		Source:              v1.EventSource{Component: reporterComponent, Host: r.nodeName},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		Type:                v1.EventTypeNormal,
+		ReportingController: reporterComponent,
+		ReportingInstance:   r.nodeName,
+	}
+	if t.To != pluginapi.Healthy {
+		e.Type = v1.EventTypeWarning
+	}
+	if t.From != "" {
+		e.Message += fmt.Sprintf(", was %s", t.From)
+	}
+	return e
+}
+
+func (r *reporter) conditionOf(unhealthy []string, total int) *v1.NodeCondition {
+	c := &v1.NodeCondition{
+		Type:    r.condition,
+		Status:  v1.ConditionTrue,
+		Reason:  "DevicesHealthy",
+		Message: fmt.Sprintf("All %d devices of %s are healthy", total, r.resourceName),
+	}
+	if len(unhealthy) > 0 {
+		named := unhealthy
+		if len(named) > maxConditionDevices {
+			named = append(named[:maxConditionDevices:maxConditionDevices], "...")
+		}
+		c.Status = v1.ConditionFalse
+		c.Reason = "DevicesUnhealthy"
+		c.Message = fmt.Sprintf("%d of %d devices of %s are unhealthy: %s", len(unhealthy), total, r.resourceName, strings.Join(named, ", "))
+	}
+	return c
+}
+
+// run sends events and condition until stop is closed.
+func (r *reporter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(conditionRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-r.events:
+			if err := r.client.CreateEvent(e); err != nil {
+				log.Printf("Could not create event %s: %v", e.Message, err)
+			}
+		case <-r.changed:
+			r.setCondition()
+		case <-ticker.C:
+			r.setCondition()
+		}
+	}
+}
+
+func (r *reporter) setCondition() {
+	r.lock.Lock()
+	c := r.pending
+	if c == nil {
+		r.lock.Unlock()
+		return
+	}
+	updated := *c
+	now := metav1.Now()
+	updated.LastHeartbeatTime = now
+	updated.LastTransitionTime = metav1.NewTime(r.statusChangedAt)
+	if updated.Status != r.status || r.statusChangedAt.IsZero() {
+		updated.LastTransitionTime = now
+	}
+	r.lock.Unlock()
+
+	if err := r.client.SetNodeCondition(r.nodeName, updated); err != nil {
+		log.Printf("Could not set condition %s of node %s: %v", r.condition, r.nodeName, err)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.status = updated.Status
+	r.statusChangedAt = updated.LastTransitionTime.Time
+	// Keep condition reported since, if any.
+	if r.pending == c {
+		r.pending = nil
+	}
+}
@@ -0,0 +1,172 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// recordingClient records events and conditions, failing if err is set.
+type recordingClient struct {
+	lock       sync.Mutex
+	err        error
+	events     []*v1.Event
+	conditions []v1.NodeCondition
+}
+
+func (c *recordingClient) CreateEvent(event *v1.Event) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.events = append(c.events, event)
+	return nil
+}
+
+func (c *recordingClient) SetNodeCondition(nodeName string, condition v1.NodeCondition) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.conditions = append(c.conditions, condition)
+	return nil
+}
+
+func TestConditionOf(t *testing.T) {
+	r := newReporter("example.com/gpu", &ReporterConfig{NodeName: "node-0", ConditionType: "GPUHealthy"})
+	var many []string
+	for i := 0; i < 12; i++ {
+		many = append(many, fmt.Sprintf("gpu-%02d", i))
+	}
+	for _, tc := range []struct {
+		unhealthy []string
+		total     int
+		status    v1.ConditionStatus
+		reason    string
+		message   string
+	}{
+		{
+			total:   4,
+			status:  v1.ConditionTrue,
+			reason:  "DevicesHealthy",
+			message: "All 4 devices of example.com/gpu are healthy",
+		},
+		{
+			unhealthy: []string{"gpu-1", "gpu-3"},
+			total:     4,
+			status:    v1.ConditionFalse,
+			reason:    "DevicesUnhealthy",
+			message:   "2 of 4 devices of example.com/gpu are unhealthy: gpu-1, gpu-3",
+		},
+		{
+			unhealthy: many,
+			total:     16,
+			status:    v1.ConditionFalse,
+			reason:    "DevicesUnhealthy",
+			message:   "12 of 16 devices of example.com/gpu are unhealthy: " + strings.Join(many[:10], ", ") + ", ...",
+		},
+	} {
+		c := r.conditionOf(tc.unhealthy, tc.total)
+		if c.Type != "GPUHealthy" || c.Status != tc.status || c.Reason != tc.reason || c.Message != tc.message {
+			t.Errorf("got condition %s %s %s %q, want GPUHealthy %s %s %q", c.Type, c.Status, c.Reason, c.Message, tc.status, tc.reason, tc.message)
+		}
+	}
+	if len(many) != 12 {
+		t.Errorf("unhealthy devices are changed to %v", many)
+	}
+}
+
+func TestReporterCondition(t *testing.T) {
+	client := &recordingClient{}
+	r := newReporter("example.com/gpu", &ReporterConfig{Client: client, NodeName: "node-0", ConditionType: "GPUHealthy"})
+	healthy := func(ids ...string) []*Device {
+		var devs []*Device
+		for _, id := range ids {
+			devs = append(devs, &Device{ID: id, Health: pluginapi.Healthy})
+		}
+		return devs
+	}
+	withUnhealthy := append(healthy("gpu-0"), &Device{ID: "gpu-1", Health: pluginapi.Unhealthy})
+
+	var transitionTime string
+	for _, step := range []struct {
+		name string
+		devs []*Device
+		fail bool
+		// message is of the condition set, "" if none is set.
+		message string
+		// transition is whether transition time changes.
+		transition bool
+	}{
+		{name: "first", devs: healthy("gpu-0"), message: "All 1 devices of example.com/gpu are healthy", transition: true},
+		{name: "unchanged", devs: healthy("gpu-0")},
+		{name: "device added", devs: healthy("gpu-0", "gpu-1"), message: "All 2 devices of example.com/gpu are healthy"},
+		{name: "set failed", devs: withUnhealthy, fail: true},
+		{name: "retried", message: "1 of 2 devices of example.com/gpu are unhealthy: gpu-1", transition: true},
+		{name: "device removed", devs: healthy("gpu-0"), message: "All 1 devices of example.com/gpu are healthy", transition: true},
+	} {
+		t.Run(step.name, func(t *testing.T) {
+			client.err = nil
+			if step.fail {
+				client.err = fmt.Errorf("forbidden")
+			}
+			set := len(client.conditions)
+			if step.devs != nil {
+				r.report(nil, step.devs)
+			}
+			r.setCondition()
+
+			if step.message == "" {
+				if len(client.conditions) != set {
+					t.Errorf("set condition %q, want none", client.conditions[len(client.conditions)-1].Message)
+				}
+				return
+			}
+			if len(client.conditions) != set+1 {
+				t.Fatalf("set %d conditions, want 1", len(client.conditions)-set)
+			}
+			c := client.conditions[len(client.conditions)-1]
+			if c.Message != step.message {
+				t.Errorf("got message %q, want %q", c.Message, step.message)
+			}
+			if changed := c.LastTransitionTime.String() != transitionTime; changed != step.transition {
+				t.Errorf("transition time changed is %v, want %v", changed, step.transition)
+			}
+			transitionTime = c.LastTransitionTime.String()
+		})
+	}
+}
+
+func TestReporterEvents(t *testing.T) {
+	r := newReporter("example.com/gpu", &ReporterConfig{NodeName: "node-0"})
+	r.report([]healthTransition{
+		{ID: "gpu-0", To: pluginapi.Healthy},
+		{ID: "gpu-1", To: pluginapi.Unhealthy},
+		{ID: "gpu-2", From: pluginapi.Unhealthy, To: pluginapi.Healthy},
+	}, nil)
+	close(r.events)
+
+	var got []string
+	for e := range r.events {
+		got = append(got, e.Type+" "+e.Reason+": "+e.Message)
+		if e.InvolvedObject.Name != "node-0" || e.Namespace != "default" {
+			t.Errorf("event is of %s in %s", e.InvolvedObject.Name, e.Namespace)
+		}
+	}
+	want := []string{
+		"Warning DeviceUnhealthy: Device gpu-1 of example.com/gpu is Unhealthy",
+		"Normal DeviceHealthy: Device gpu-2 of example.com/gpu is Healthy, was Unhealthy",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if r.pending != nil {
+		t.Errorf("condition is pending with no condition type")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"log"
 
 	"github.com/fsnotify/fsnotify"
-	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 // Run keeps device plugin running, recover from error.
@@ -15,15 +14,28 @@ func Run(config Config, sigCh <-chan bool) error {
 	if err := config.Validate(); err != nil {
 		return err
 	}
+	if config.DryRun != nil {
+		return runDry(config, sigCh)
+	}
 
-	watcher, err := newFSWatcher(pluginapi.KubeletSocket)
+	// Watch the directory, since kubelet socket is removed and created
+	// again when kubelet restarts.
+	watcher, err := newFSWatcher(config.pluginDir())
 	if err != nil {
 		return err
 	}
 	defer watcher.Close()
 
+	// Devices are kept across restarts, so that kubelet gets them at once.
+	state := newResourceState(config)
+	registerState(state)
+	defer unregisterState(state)
+	stop := make(chan struct{})
+	defer close(stop)
+	state.run(config, stop)
+
 	for {
-		if restart, err := runOnce(config, watcher, sigCh); err != nil {
+		if restart, err := runOnce(config, state, watcher, sigCh); err != nil {
 			return err
 		} else if !restart {
 			return nil
@@ -31,17 +43,19 @@ func Run(config Config, sigCh <-chan bool) error {
 	}
 }
 
-func runOnce(config Config, watcher *fsnotify.Watcher, sigCh <-chan bool) (bool, error) {
-	plugin := ForConfig(config)
+func runOnce(config Config, state *resourceState, watcher *fsnotify.Watcher, sigCh <-chan bool) (bool, error) {
+	plugin := newDevicePlugin(config, state)
 	if err := plugin.Start(); err != nil {
 		return false, fmt.Errorf("fail to start plugin: %v", err)
 	}
+	defer plugin.Stop()
 
 	for {
 		select {
 		case event := <-watcher.Events:
-			if event.Op&fsnotify.Create == fsnotify.Create {
+			if event.Name == config.kubeletSocket() && event.Op&fsnotify.Create == fsnotify.Create {
 				log.Println("Kubelet is restarted. Restart device plugin.")
+				state.kubeletRestarted()
 				return true, nil
 			}
 		case err := <-watcher.Errors:
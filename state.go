@@ -0,0 +1,255 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	maxHealthHistory  = 16
+	maxAllocationLogs = 256
+)
+
+// resourceState is the state of a resource kept across plugin restarts.
+type resourceState struct {
+	resourceName string
+	cache        *deviceCache
+	ledger       *allocationLedger
+	cordons      *idSet
+	// allocations is set if PreStartContainer needs allocations.
+	allocations *allocationMemo
+	// owners is set if owners of devices are tracked.
+	owners *ownerIndex
+	// releaser is set if devices are released by ReleaseFunc.
+	releaser *releaser
+	// reporter is set if health of devices is reported to Kubernetes.
+	reporter *reporter
+	// inventory is set if device counts are published as labels.
+	inventory *inventory
+	// cdi is set if a CDI spec of devices is written.
+	cdi *cdiSpec
+	// dryRun is the plugin called by admin API if it runs in dry run.
+	dryRun *generalDevicePlugin
+
+	lock         sync.Mutex
+	registered   bool
+	registeredAt time.Time
+	registerErr  error
+	restarts     int
+}
+
+func newResourceState(conf Config) *resourceState {
+	s := &resourceState{
+		resourceName: conf.ResourceName,
+		cache:        newDeviceCache(),
+		ledger:       newAllocationLedger(),
+		cordons:      loadIDSet(stateFile(conf.StateDir, conf.ResourceName, "cordons.json")),
+	}
+	if conf.AllocationPreStartFunc != nil {
+		s.allocations = newAllocationMemo(conf.AllocationTTL)
+	}
+	if conf.ReleaseFunc != nil || conf.OwnerSource != nil {
+		s.owners = newOwnerIndex(conf, s.ledger)
+	}
+	if conf.ReleaseFunc != nil {
+		s.releaser = newReleaser(conf, s.cache.notify)
+		s.owners.refreshed = s.releaser.poll
+	}
+	if conf.Reporter != nil {
+		s.reporter = newReporter(conf.ResourceName, conf.Reporter)
+		s.cache.onHealth = s.reporter.report
+	}
+	if conf.CDI != nil {
+		s.cdi = newCDISpec(conf.ResourceName, conf.CDI)
+		s.cache.beforeSet = s.cdi.write
+	}
+	if conf.Inventory != nil {
+		s.inventory = newInventory(conf)
+	}
+	return s
+}
+
+// run feeds devices, tracks owners of devices to release them if
+// ReleaseFunc is set, and reports health and inventory if configured, until
+// stop is closed.
+func (s *resourceState) run(conf Config, stop <-chan struct{}) {
+	go s.cache.feed(conf, stop)
+	if s.owners != nil {
+		go s.owners.run(stop)
+	}
+	if s.reporter != nil {
+		go s.reporter.run(stop)
+	}
+	if s.inventory != nil {
+		go s.inventory.run(s.cache, stop)
+	}
+}
+
+func (s *resourceState) setRegistered(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.registered = err == nil
+	s.registerErr = err
+	if err == nil {
+		s.registeredAt = time.Now()
+	}
+}
+
+func (s *resourceState) kubeletRestarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.registered = false
+	s.restarts++
+}
+
+// cordon cordons or uncordons device with id. Only known devices can be
+// cordoned, while any can be uncordoned.
+func (s *resourceState) cordon(id string, cordoned bool) error {
+	if _, ok := s.cache.Get(id); cordoned && !ok {
+		return fmt.Errorf("unknown device %s", id)
+	}
+
+	changed, err := s.cordons.Set(id, cordoned)
+	if err != nil {
+		return err
+	}
+	if changed {
+		log.Printf("Device %s of %s is cordoned: %v", id, s.resourceName, cordoned)
+		s.cache.notify()
+	}
+	return nil
+}
+
+// advertised returns devices as sent to kubelet, with ones cordoned, being
+// released or left out of CDI spec Unhealthy, and false if source has not
+// reported yet.
+// Devices are shared with the cache unless overridden, so that sends cost
+// no allocation in common cases.
+func (s *resourceState) advertised() ([]*pluginapi.Device, bool) {
+	ids := s.cordons.List()
+	if s.releaser != nil {
+		ids = append(ids, s.releaser.releasingIDs()...)
+	}
+	if s.cdi != nil {
+		ids = append(ids, s.cdi.LeftOut()...)
+	}
+	return s.cache.APIListUnhealthy(ids)
+}
+
+type healthEvent struct {
+	Time   time.Time `json:"time"`
+	Health string    `json:"health"`
+}
+
+type allocationRecord struct {
+	Time    time.Time `json:"time"`
+	Devices []string  `json:"devices"`
+	Error   string    `json:"error,omitempty"`
+	// Owners are containers devices are found assigned to after
+	// allocation.
+	Owners map[string]Owner `json:"owners,omitempty"`
+}
+
+// allocationLedger records recent allocations, and the last time each
+// device was allocated.
+type allocationLedger struct {
+	lock    sync.RWMutex
+	records []allocationRecord
+	last    map[string]time.Time
+}
+
+func newAllocationLedger() *allocationLedger {
+	return &allocationLedger{last: map[string]time.Time{}}
+}
+
+func (l *allocationLedger) Record(ids []string, err error) {
+	r := allocationRecord{Time: time.Now(), Devices: ids}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.records) >= maxAllocationLogs {
+		l.records = append(l.records[:0], l.records[1:]...)
+	}
+	l.records = append(l.records, r)
+	if err == nil {
+		for _, id := range ids {
+			l.last[id] = r.Time
+		}
+	}
+}
+
+// Attribute sets owners of devices in the last successful allocation of
+// each.
+func (l *allocationLedger) Attribute(owners map[string]Owner) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// Records are scanned once from the latest, so that cost doesn't grow
+	// with owners times records.
+	done := make(map[string]bool, len(owners))
+	for i := len(l.records) - 1; i >= 0 && len(done) < len(owners); i-- {
+		r := &l.records[i]
+		if r.Error != "" {
+			continue
+		}
+		var updated map[string]Owner
+		for _, id := range r.Devices {
+			o, ok := owners[id]
+			if !ok || done[id] {
+				continue
+			}
+			done[id] = true
+			if updated == nil {
+				// Records returned are not changed, so copy owners.
+				updated = make(map[string]Owner, len(r.Owners)+1)
+				for k, v := range r.Owners {
+					updated[k] = v
+				}
+			}
+			updated[id] = o
+		}
+		if updated != nil {
+			r.Owners = updated
+		}
+	}
+}
+
+func (l *allocationLedger) Records() []allocationRecord {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return append([]allocationRecord(nil), l.records...)
+}
+
+// LastAllocated returns the last time device with id was allocated.
+func (l *allocationLedger) LastAllocated(id string) (time.Time, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	t, ok := l.last[id]
+	return t, ok
+}
+
+// states are resources running in this process, served by admin endpoint.
+var states = struct {
+	sync.RWMutex
+	m map[string]*resourceState
+}{m: map[string]*resourceState{}}
+
+func registerState(s *resourceState) {
+	states.Lock()
+	defer states.Unlock()
+	states.m[s.resourceName] = s
+}
+
+func unregisterState(s *resourceState) {
+	states.Lock()
+	defer states.Unlock()
+	if states.m[s.resourceName] == s {
+		delete(states.m, s.resourceName)
+	}
+}
@@ -0,0 +1,203 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// TokenPoolConfig configures a pool of abstract capacity, such as license
+// seats, which has no device node.
+type TokenPoolConfig struct {
+	// Tokens are named Prefix followed by index, such as token-0.
+	Prefix string
+	Count  int
+	// Env is set in containers getting tokens.
+	Env map[string]string
+	// IDEnv, if set, is the env holding tokens a container gets, separated
+	// by comma.
+	IDEnv string
+	// Owners, if set, lists tokens of ResourceName in use, which are found
+	// at start and before shrinking, so that tokens allocated before the
+	// pool restarts are not removed while in use.
+	Owners       OwnerSource
+	ResourceName string
+}
+
+// TokenPool advertises Count synthetic devices, which can be resized at
+// runtime.
+type TokenPool struct {
+	conf TokenPoolConfig
+
+	lock  sync.Mutex
+	count int
+	// inUse are indexes of tokens allocated and not released.
+	inUse   map[int]bool
+	changed chan struct{}
+}
+
+func NewTokenPool(conf TokenPoolConfig) (*TokenPool, error) {
+	if conf.Count < 0 {
+		return nil, fmt.Errorf("count cannot be negative")
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "token-"
+	}
+	p := &TokenPool{conf: conf, count: conf.Count, inUse: map[int]bool{}, changed: make(chan struct{}, 1)}
+	if conf.Owners != nil {
+		if err := p.findInUse(); err != nil {
+			log.Printf("Could not list tokens in use: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// findInUse marks tokens listed by Owners in use.
+func (p *TokenPool) findInUse() error {
+	owners, err := p.conf.Owners.Owners(p.conf.ResourceName)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for id := range owners {
+		if i, ok := p.index(id); ok && !p.inUse[i] {
+			p.inUse[i] = true
+			if i >= p.count {
+				p.notifyLocked()
+			}
+		}
+	}
+	return nil
+}
+
+// Resize changes number of tokens. Tokens are added or removed from the end,
+// so that IDs of the others are kept. Tokens removed while in use are kept
+// Unhealthy until released, so that IDs handed out are never reused nor
+// taken from containers.
+func (p *TokenPool) Resize(count int) error {
+	if count < 0 {
+		return fmt.Errorf("count cannot be negative")
+	}
+	if p.conf.Owners != nil && count < p.Count() {
+		if err := p.findInUse(); err != nil {
+			return fmt.Errorf("could not list tokens in use: %v", err)
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.count != count {
+		p.count = count
+		p.notifyLocked()
+	}
+	return nil
+}
+
+// Release frees token of id, which is removed if pool has shrunk below it.
+// Set it to Config.ReleaseFunc, so that the plugin finds when tokens are
+// free.
+func (p *TokenPool) Release(id string) error {
+	i, ok := p.index(id)
+	if !ok {
+		return fmt.Errorf("unknown token %s", id)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.inUse[i] {
+		delete(p.inUse, i)
+		if i >= p.count {
+			p.notifyLocked()
+		}
+	}
+	return nil
+}
+
+func (p *TokenPool) notifyLocked() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// index returns index of token id. IDs such as token-01 are not tokens,
+// which would alias token-1.
+func (p *TokenPool) index(id string) (int, bool) {
+	if !strings.HasPrefix(id, p.conf.Prefix) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(id, p.conf.Prefix))
+	return i, err == nil && i >= 0 && id == fmt.Sprintf("%s%d", p.conf.Prefix, i)
+}
+
+// Count returns number of tokens.
+func (p *TokenPool) Count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.count
+}
+
+// Devices sends tokens whenever pool is resized, until stop is closed.
+func (p *TokenPool) Devices(stop <-chan struct{}) <-chan []*Device {
+	ch := make(chan []*Device)
+	go func() {
+		for {
+			if !sendDevices(ch, p.list(), stop) {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-p.changed:
+			}
+		}
+	}()
+	return ch
+}
+
+// Allocate sets Env, and IDEnv to ids, which are in use until released.
+func (p *TokenPool) Allocate(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	p.lock.Lock()
+	for _, id := range ids {
+		if i, ok := p.index(id); ok {
+			p.inUse[i] = true
+		}
+	}
+	p.lock.Unlock()
+
+	resp := &pluginapi.ContainerAllocateResponse{Envs: map[string]string{}}
+	for k, v := range p.conf.Env {
+		resp.Envs[k] = v
+	}
+	if p.conf.IDEnv != "" {
+		resp.Envs[p.conf.IDEnv] = strings.Join(ids, ",")
+	}
+	return resp, nil
+}
+
+func (p *TokenPool) list() []*Device {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	devs := make([]*Device, 0, p.count)
+	for i := 0; i < p.count; i++ {
+		devs = append(devs, &Device{ID: fmt.Sprintf("%s%d", p.conf.Prefix, i), Health: pluginapi.Healthy})
+	}
+
+	var removed []int
+	for i := range p.inUse {
+		if i >= p.count {
+			removed = append(removed, i)
+		}
+	}
+	sort.Ints(removed)
+	for _, i := range removed {
+		devs = append(devs, &Device{ID: fmt.Sprintf("%s%d", p.conf.Prefix, i), Health: pluginapi.Unhealthy})
+	}
+	return devs
+}
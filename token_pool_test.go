@@ -0,0 +1,142 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// tokensOf returns IDs and health of tokens advertised by p.
+func tokensOf(p *TokenPool) string {
+	var got []string
+	for _, d := range p.list() {
+		got = append(got, fmt.Sprintf("%s %s", d.ID, d.Health))
+	}
+	return strings.Join(got, ", ")
+}
+
+func TestTokenPoolIndex(t *testing.T) {
+	p, err := NewTokenPool(TokenPoolConfig{Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		id    string
+		index int
+		ok    bool
+	}{
+		{id: "token-0", index: 0, ok: true},
+		{id: "token-12", index: 12, ok: true},
+		{id: "token-01"},
+		{id: "token-+1"},
+		{id: "token--1"},
+		{id: "token-"},
+		{id: "token-1a"},
+		{id: "other-1"},
+	} {
+		i, ok := p.index(tc.id)
+		if ok != tc.ok || (ok && i != tc.index) {
+			t.Errorf("index of %s is %d %v, want %d %v", tc.id, i, ok, tc.index, tc.ok)
+		}
+	}
+}
+
+func TestTokenPoolResize(t *testing.T) {
+	p, err := NewTokenPool(TokenPoolConfig{Prefix: "seat-", Count: 3, Env: map[string]string{"LICENSE": "x"}, IDEnv: "SEATS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Allocate([]string{"seat-1", "seat-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Envs["LICENSE"] != "x" || resp.Envs["SEATS"] != "seat-1,seat-2" {
+		t.Errorf("got envs %v", resp.Envs)
+	}
+
+	for _, step := range []struct {
+		name    string
+		resize  int
+		release string
+		want    string
+	}{
+		{name: "shrunk", resize: 1, want: "seat-0 Healthy, seat-1 Unhealthy, seat-2 Unhealthy"},
+		{name: "aliased release", release: "seat-01", want: "seat-0 Healthy, seat-1 Unhealthy, seat-2 Unhealthy"},
+		{name: "released", release: "seat-2", want: "seat-0 Healthy, seat-1 Unhealthy"},
+		{name: "grown", resize: 2, want: "seat-0 Healthy, seat-1 Healthy"},
+		{name: "shrunk after release", release: "seat-1", resize: 1, want: "seat-0 Healthy"},
+	} {
+		t.Run(step.name, func(t *testing.T) {
+			if step.release != "" {
+				p.Release(step.release)
+			}
+			if step.resize != 0 {
+				if err := p.Resize(step.resize); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := tokensOf(p); got != step.want {
+				t.Errorf("got tokens %s, want %s", got, step.want)
+			}
+		})
+	}
+
+	if err := p.Resize(-1); err == nil {
+		t.Errorf("resized to -1")
+	}
+	if err := p.Release("seat-x"); err == nil {
+		t.Errorf("released unknown token")
+	}
+}
+
+func TestTokenPoolOwners(t *testing.T) {
+	owners := &fakeOwners{owners: map[string]Owner{
+		"token-1":  {Pod: "a", Container: "c"},
+		"token-3":  {Pod: "b", Container: "c"},
+		"token-03": {Pod: "c", Container: "c"},
+	}}
+	p, err := NewTokenPool(TokenPoolConfig{Count: 3, Owners: owners, ResourceName: "example.com/token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token-3 was allocated before the pool restarted smaller.
+	if got, want := tokensOf(p), "token-0 Healthy, token-1 Healthy, token-2 Healthy, token-3 Unhealthy"; got != want {
+		t.Errorf("got tokens %s, want %s", got, want)
+	}
+
+	// Owners failing keeps the pool.
+	owners.err = fmt.Errorf("kubelet is down")
+	if err := p.Resize(1); err == nil {
+		t.Errorf("resized with owners failing")
+	}
+	if p.Count() != 3 {
+		t.Errorf("got count %d, want 3", p.Count())
+	}
+
+	owners.err = nil
+	if err := p.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokensOf(p), "token-0 Healthy, token-1 Unhealthy, token-3 Unhealthy"; got != want {
+		t.Errorf("got tokens %s, want %s", got, want)
+	}
+}
+
+func TestTokenPoolDevices(t *testing.T) {
+	p, err := NewTokenPool(TokenPoolConfig{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	ch := p.Devices(stop)
+	if devs := <-ch; len(devs) != 1 || devs[0].Health != pluginapi.Healthy {
+		t.Fatalf("got devices %v", devs)
+	}
+	p.Resize(2)
+	if devs := <-ch; len(devs) != 2 {
+		t.Fatalf("got %d devices after resize, want 2", len(devs))
+	}
+}
@@ -0,0 +1,95 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// SysfsRoot is where sysfs is mounted. Change it if the plugin runs in a
+// container with host sysfs mounted elsewhere.
+var SysfsRoot = "/sys"
+
+// TopologyFunc returns the topology of a device, nil if it is unknown.
+type TopologyFunc func(id string) (*pluginapi.TopologyInfo, error)
+
+// PCITopologyFunc returns a TopologyFunc which reads the NUMA node of PCI
+// devices from sysfs. busID maps device ID to its PCI address, such as
+// "0000:3b:00.0".
+func PCITopologyFunc(busID func(id string) string) TopologyFunc {
+	return func(id string) (*pluginapi.TopologyInfo, error) {
+		return SysfsTopology(filepath.Join(SysfsRoot, "bus/pci/devices", busID(id)))
+	}
+}
+
+// SysfsTopology reads numa_node under the sysfs device directory, and
+// returns nil if the platform reports no NUMA affinity.
+func SysfsTopology(dir string) (*pluginapi.TopologyInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "numa_node"))
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid numa_node in %s: %v", dir, err)
+	}
+	if node < 0 {
+		return nil, nil
+	}
+	return &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: node}}}, nil
+}
+
+// TopologyGroup groups devices by NUMA node, for use with PackPolicy and
+// SpreadPolicy.
+func TopologyGroup(topology TopologyFunc) GroupFunc {
+	return func(id string) string {
+		t, err := topology(id)
+		if err != nil || t == nil {
+			return ""
+		}
+
+		nodes := make([]string, 0, len(t.Nodes))
+		for _, n := range t.Nodes {
+			nodes = append(nodes, strconv.FormatInt(n.ID, 10))
+		}
+		return strings.Join(nodes, ",")
+	}
+}
+
+// withTopology fills in topology of devices which have none, and returns
+// them with topology found for each. Topology in known is reused rather than
+// looked up again for thousands of devices on every update, including none
+// found, which is common for devices with no NUMA affinity. Failed lookups
+// are not kept, so they are tried again on the next update.
+func withTopology(devs []*Device, topology TopologyFunc, known map[string]*pluginapi.TopologyInfo) ([]*Device, map[string]*pluginapi.TopologyInfo) {
+	filled := make([]*Device, 0, len(devs))
+	found := make(map[string]*pluginapi.TopologyInfo, len(known))
+	for _, d := range devs {
+		if d.Topology == nil {
+			t, ok := known[d.ID]
+			if !ok {
+				var err error
+				if t, err = topology(d.ID); err != nil {
+					log.Printf("Could not get topology of device %s: %v", d.ID, err)
+				}
+				ok = err == nil
+			}
+			if ok {
+				found[d.ID] = t
+			}
+			if t != nil {
+				copied := *d
+				copied.Topology = t
+				d = &copied
+			}
+		}
+		filled = append(filled, d)
+	}
+	return filled, found
+}
@@ -0,0 +1,116 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestWithTopology(t *testing.T) {
+	calls := map[string]int{}
+	fail := true
+	topology := func(id string) (*pluginapi.TopologyInfo, error) {
+		calls[id]++
+		switch id {
+		case "numa":
+			return &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: 1}}}, nil
+		case "flaky":
+			if fail {
+				return nil, fmt.Errorf("sysfs read failed")
+			}
+			return &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: 0}}}, nil
+		default:
+			return nil, nil
+		}
+	}
+	given := &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: 2}}}
+	devs := []*Device{{ID: "numa"}, {ID: "none"}, {ID: "flaky"}, {ID: "given", Topology: given}}
+
+	filled, known := withTopology(devs, topology, nil)
+	if filled[0].Topology == nil || filled[0].Topology.Nodes[0].ID != 1 || devs[0].Topology != nil {
+		t.Errorf("numa is not filled in on a copy: %+v", filled[0])
+	}
+	if filled[2].Topology != nil || filled[3] != devs[3] {
+		t.Errorf("flaky or given is changed: %+v, %+v", filled[2], filled[3])
+	}
+	if _, ok := known["flaky"]; ok {
+		t.Error("failed lookup is kept")
+	}
+	if _, ok := known["none"]; !ok {
+		t.Error("no topology found is not kept")
+	}
+
+	// Known topologies are reused, while failed lookups are retried.
+	fail = false
+	filled, _ = withTopology(devs, topology, known)
+	if filled[2].Topology == nil || filled[2].Topology.Nodes[0].ID != 0 {
+		t.Errorf("flaky is not filled in after retry: %+v", filled[2])
+	}
+	want := map[string]int{"numa": 1, "none": 1, "flaky": 2}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("got lookups %v, want %v", calls, want)
+	}
+}
+
+func TestSysfsTopology(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	devices := filepath.Join(root, "bus/pci/devices")
+	for addr, node := range map[string]string{
+		"0000:3b:00.0": "1\n",
+		"0000:5e:00.0": "0\n",
+		"0000:af:00.0": "-1\n",
+		"0000:d8:00.0": "unknown\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(devices, addr), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(devices, addr, "numa_node"), []byte(node), 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		addr string
+		want string
+		err  bool
+	}{
+		{addr: "0000:3b:00.0", want: "1"},
+		{addr: "0000:5e:00.0", want: "0"},
+		// No NUMA affinity.
+		{addr: "0000:af:00.0", want: ""},
+		{addr: "0000:d8:00.0", err: true},
+		{addr: "0000:00:00.0", err: true},
+	} {
+		got, err := SysfsTopology(filepath.Join(devices, tc.addr))
+		if (err != nil) != tc.err {
+			t.Errorf("%s: got error %v, want error %v", tc.addr, err, tc.err)
+			continue
+		}
+		if nodes := TopologyGroup(func(string) (*pluginapi.TopologyInfo, error) { return got, err })(tc.addr); nodes != tc.want {
+			t.Errorf("%s: got NUMA nodes %q, want %q", tc.addr, nodes, tc.want)
+		}
+	}
+
+	defer func(old string) { SysfsRoot = old }(SysfsRoot)
+	SysfsRoot = root
+	busIDs := map[string]string{"gpu-0": "0000:3b:00.0", "gpu-1": "0000:5e:00.0", "gpu-2": "0000:3b:00.0"}
+	topology := PCITopologyFunc(func(id string) string { return busIDs[id] })
+	if got, err := topology("gpu-0"); err != nil || got == nil || got.Nodes[0].ID != 1 {
+		t.Errorf("got topology %v, error %v of gpu-0, want NUMA node 1", got, err)
+	}
+
+	// Devices on the same NUMA node are packed.
+	chosen, err := PackPolicy(TopologyGroup(topology))([]string{"gpu-0", "gpu-1", "gpu-2"}, nil, 2)
+	if err != nil || !reflect.DeepEqual(chosen, []string{"gpu-0", "gpu-2"}) {
+		t.Errorf("got %v, error %v packed, want [gpu-0 gpu-2]", chosen, err)
+	}
+}
@@ -0,0 +1,87 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// ResponseValidator checks a response of Allocate before it's returned to
+// kubelet. ValidateResponse is provided.
+type ResponseValidator func(resp *pluginapi.ContainerAllocateResponse) error
+
+// ValidateResponse checks that host paths of mounts and devices exist,
+// container paths are absolute and unique, device permissions are made of
+// r, w and m, and env names are valid. It reports all problems found, which
+// otherwise show up only as a failure of container runtime.
+func ValidateResponse(resp *pluginapi.ContainerAllocateResponse) error {
+	var problems []string
+	containerPaths := map[string]string{}
+	checkPaths := func(what, hostPath, containerPath string) {
+		if !filepath.IsAbs(hostPath) {
+			problems = append(problems, fmt.Sprintf("%s: host path %q is not absolute", what, hostPath))
+		} else if _, err := os.Stat(hostPath); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", what, err))
+		}
+		// Container paths are in Linux containers whatever the host is.
+		if !path.IsAbs(containerPath) {
+			problems = append(problems, fmt.Sprintf("%s: container path %q is not absolute", what, containerPath))
+			return
+		}
+		clean := path.Clean(containerPath)
+		if other, ok := containerPaths[clean]; ok {
+			problems = append(problems, fmt.Sprintf("%s: container path %s is also used by %s", what, clean, other))
+			return
+		}
+		containerPaths[clean] = what
+	}
+
+	for i, m := range resp.GetMounts() {
+		checkPaths(fmt.Sprintf("mount %d", i), m.HostPath, m.ContainerPath)
+	}
+	for i, d := range resp.GetDevices() {
+		what := fmt.Sprintf("device %d", i)
+		checkPaths(what, d.HostPath, d.ContainerPath)
+		if !validPermissions(d.Permissions) {
+			problems = append(problems, fmt.Sprintf("%s: permissions %q are not one or more of r, w and m", what, d.Permissions))
+		}
+	}
+
+	// Envs are checked in order, so that the same problems are reported the
+	// same way.
+	names := make([]string, 0, len(resp.GetEnvs()))
+	for name := range resp.GetEnvs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, msg := range validation.IsEnvVarName(name) {
+			problems = append(problems, fmt.Sprintf("env %q: %s", name, msg))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validPermissions(perms string) bool {
+	if perms == "" {
+		return false
+	}
+	seen := map[rune]bool{}
+	for _, c := range perms {
+		if !strings.ContainsRune("rwm", c) || seen[c] {
+			return false
+		}
+		seen[c] = true
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package deviceplugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestValidateResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	host := filepath.Join(dir, "dev0")
+	if err := ioutil.WriteFile(host, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	for _, tc := range []struct {
+		name string
+		resp *pluginapi.ContainerAllocateResponse
+		// want are problems reported, none if empty.
+		want []string
+	}{
+		{
+			name: "valid",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Envs:    map[string]string{"DEV": "0", "_X.y-z": "1"},
+				Mounts:  []*pluginapi.Mount{{HostPath: dir, ContainerPath: "/data"}},
+				Devices: []*pluginapi.DeviceSpec{{HostPath: host, ContainerPath: "/dev/dev0", Permissions: "rwm"}},
+			},
+		},
+		{name: "empty", resp: &pluginapi.ContainerAllocateResponse{}},
+		{
+			name: "relative paths",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Mounts: []*pluginapi.Mount{{HostPath: "data", ContainerPath: "data"}},
+			},
+			want: []string{
+				`mount 0: host path "data" is not absolute`,
+				`mount 0: container path "data" is not absolute`,
+			},
+		},
+		{
+			name: "missing host path",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Devices: []*pluginapi.DeviceSpec{{HostPath: missing, ContainerPath: "/dev/x", Permissions: "r"}},
+			},
+			want: []string{"device 0: stat " + missing},
+		},
+		{
+			name: "duplicated container paths",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Mounts:  []*pluginapi.Mount{{HostPath: dir, ContainerPath: "/dev/dev0"}},
+				Devices: []*pluginapi.DeviceSpec{{HostPath: host, ContainerPath: "/dev/./dev0/", Permissions: "rw"}},
+			},
+			want: []string{"device 0: container path /dev/dev0 is also used by mount 0"},
+		},
+		{
+			name: "invalid permissions",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Devices: []*pluginapi.DeviceSpec{
+					{HostPath: host, ContainerPath: "/dev/a"},
+					{HostPath: host, ContainerPath: "/dev/b", Permissions: "rx"},
+					{HostPath: host, ContainerPath: "/dev/c", Permissions: "rr"},
+				},
+			},
+			want: []string{
+				`device 0: permissions "" are not one or more of r, w and m`,
+				`device 1: permissions "rx" are not one or more of r, w and m`,
+				`device 2: permissions "rr" are not one or more of r, w and m`,
+			},
+		},
+		{
+			name: "invalid env names",
+			resp: &pluginapi.ContainerAllocateResponse{
+				Envs: map[string]string{"1DEV": "0", "DEV ID": "1", "OK": "2"},
+			},
+			want: []string{`env "1DEV": `, `env "DEV ID": `},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateResponse(tc.resp)
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tc.want)
+			}
+			problems := strings.Split(err.Error(), "; ")
+			if len(problems) != len(tc.want) {
+				t.Fatalf("got problems %q, want %q", problems, tc.want)
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p, tc.want[i]) {
+					t.Errorf("got problem %q, want %q", p, tc.want[i])
+				}
+			}
+		})
+	}
+}